@@ -1,9 +1,14 @@
 package base
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
 
-	"github.com/gogf/gf/os/gtime"
 	"github.com/moqsien/gkgrace"
 )
 
@@ -23,21 +28,31 @@ type IApp interface {
 	Exit() error
 }
 
-type AppContainer struct {
-	App       IApp
-	StartTime *gtime.Time // app start time
-	StopTime  *gtime.Time // app stop time
-	State     int         // status
-}
+// AppContainer records the state of a service managed by Grace, IApp is a service of it
+type AppContainer = gkgrace.AppContainer
 
 type Base struct {
-	Grace    *gkgrace.Grace
+	Grace     *gkgrace.Grace
+	Address   *gkgrace.Address
+	TLS       *gkgrace.TLSOptions // TLS of the app, certs given to Run replace its CertFiles
+	Endpoints []*Endpoint         // more addresses of the app, see AddAddr
+	listener  net.Listener
+	listening chan struct{} // closed by SetListener once the app is configured, see Start
+	once      sync.Once
+}
+
+// Endpoint is one more address of an app, with TLS of its own
+type Endpoint struct {
 	Address  *gkgrace.Address
+	TLS      *gkgrace.TLSOptions // plaintext if nil
 	listener net.Listener
 }
 
+func (that *Endpoint) GetAddr() *gkgrace.Address { return that.Address }
+func (that *Endpoint) SetGrace(g *gkgrace.Grace) {}
+
 func New() *Base {
-	return &Base{}
+	return &Base{listening: make(chan struct{})}
 }
 
 func (that *Base) FetchListener() net.Listener {
@@ -46,6 +61,35 @@ func (that *Base) FetchListener() net.Listener {
 
 func (that *Base) SetListener(l net.Listener) {
 	that.listener = l
+	if that.listening != nil {
+		that.once.Do(func() { close(that.listening) })
+	}
+}
+
+// Start call run in a goroutine and return when the app is listening or run fails, errors after that are
+// reported by Grace.ReportAppError. Execute of apps uses it, so that apps can be services of Grace, see Grace.AddService
+func (that *Base) Start(name string, run func(certs ...string) error) error {
+	if that.Grace == nil {
+		return fmt.Errorf("Grace is not set! Please use SetGrace to set it.")
+	}
+	if that.listening == nil {
+		return fmt.Errorf("Base is not created by New!")
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- run()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-that.listening:
+		go func() {
+			if err := <-errs; err != nil {
+				that.Grace.ReportAppError(name, err)
+			}
+		}()
+		return nil
+	}
 }
 
 func (that *Base) SetAddr(addr *gkgrace.Address) {
@@ -67,6 +111,157 @@ func (that *Base) GetAddr() *gkgrace.Address {
 	return that.Address
 }
 
+// AddAddr serve the app on one more address, opts is TLS of the address, plaintext if not given
+func (that *Base) AddAddr(addr *gkgrace.Address, opts ...*gkgrace.TLSOptions) {
+	if addr.Network == "" {
+		addr.Network = "tcp"
+	}
+	e := &Endpoint{Address: addr}
+	if len(opts) > 0 {
+		e.TLS = opts[0]
+	}
+	that.Endpoints = append(that.Endpoints, e)
+}
+
+// GetAddrs return all addresses of the app, the first one is GetAddr()
+func (that *Base) GetAddrs() []*gkgrace.Address {
+	addrs := []*gkgrace.Address{that.GetAddr()}
+	for _, e := range that.Endpoints {
+		addrs = append(addrs, e.Address)
+	}
+	return addrs
+}
+
+// FetchListeners return listeners of all addresses
+func (that *Base) FetchListeners() (result []net.Listener) {
+	if that.listener != nil {
+		result = append(result, that.listener)
+	}
+	for _, e := range that.Endpoints {
+		if e.listener != nil {
+			result = append(result, e.listener)
+		}
+	}
+	return
+}
+
+// ServeEndpoints get listeners of Endpoints and call serve for each in its own goroutine,
+// config is nil for plaintext, protos are ALPN of the app used if NextProtos is not set
+func (that *Base) ServeEndpoints(serve func(ln net.Listener, config *tls.Config) error, protos ...string) error {
+	configs := make([]*tls.Config, len(that.Endpoints))
+	for i, e := range that.Endpoints {
+		if e.TLS == nil {
+			continue
+		}
+		opts := *e.TLS
+		if len(opts.NextProtos) == 0 {
+			opts.NextProtos = protos
+		}
+		config, err := that.Grace.NewTLSConfig(&opts)
+		if err != nil {
+			return fmt.Errorf("%s: %s", e.Address.String(), err.Error())
+		}
+		configs[i] = config
+	}
+	for i, e := range that.Endpoints {
+		ln := that.Grace.GetListener(e)
+		if ln == nil {
+			return fmt.Errorf("Cannot get a listener for %s! ", e.Address.String())
+		}
+		e.listener = ln
+		go func(e *Endpoint, config *tls.Config) {
+			err := serve(e.listener, config)
+			if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, http.ErrServerClosed) {
+				that.Log().Error("serving stopped", "listener", e.Address.String(), "err", err)
+			}
+		}(e, configs[i])
+	}
+	return nil
+}
+
 func (that *Base) SetGrace(grace *gkgrace.Grace) {
 	that.Grace = grace
 }
+
+// SetTLS serve the app with TLS
+func (that *Base) SetTLS(opts *gkgrace.TLSOptions) {
+	that.TLS = opts
+}
+
+// Configure set addresses and TLS by listeners in config, the first one is the address of the app,
+// use it like app.Configure(g.Config.ListenersOf("api")...)
+func (that *Base) Configure(listeners ...*gkgrace.ListenerConfig) error {
+	if len(listeners) == 0 {
+		return fmt.Errorf("config: no listener configured for the app, check app of listeners")
+	}
+	for i, l := range listeners {
+		opts, err := l.TLSOptions()
+		if err != nil {
+			return fmt.Errorf("config: listener %s of app %q: %s", l.Address().String(), l.App, strings.TrimPrefix(err.Error(), "."))
+		}
+		if i == 0 {
+			that.SetAddr(l.Address())
+			if opts != nil {
+				that.SetTLS(opts)
+			}
+			continue
+		}
+		that.AddAddr(l.Address(), opts)
+	}
+	return nil
+}
+
+// NewTLSConfig return tls.Config of certs given to Run and TLSOptions, nil if TLS is not configured,
+// protos are ALPN of the app used if NextProtos is not set
+func (that *Base) NewTLSConfig(certs []string, protos ...string) (*tls.Config, error) {
+	if len(certs) == 0 && that.TLS == nil {
+		return nil, nil
+	}
+	opts := gkgrace.TLSOptions{}
+	if that.TLS != nil {
+		opts = *that.TLS
+	}
+	if len(certs) > 0 {
+		opts.CertFiles = certs
+	}
+	if len(opts.NextProtos) == 0 {
+		opts.NextProtos = protos
+	}
+	return that.Grace.NewTLSConfig(&opts)
+}
+
+// Log return the Logger of Grace, gkgrace.DefaultLogger if Grace is not set
+func (that *Base) Log() gkgrace.Logger {
+	if that.Grace == nil || that.Grace.Logger == nil {
+		return gkgrace.DefaultLogger
+	}
+	return that.Grace.Logger
+}
+
+// Readiness report readiness of Grace, not ready if Grace is not set
+func (that *Base) Readiness() (int, string) {
+	if that.Grace == nil {
+		return http.StatusServiceUnavailable, "Grace is not set"
+	}
+	return that.Grace.Readiness()
+}
+
+// Liveness report liveness of Grace
+func (that *Base) Liveness() (int, string) {
+	if that.Grace == nil {
+		return http.StatusOK, "alive"
+	}
+	return that.Grace.Liveness()
+}
+
+// HealthPaths return paths of readiness and liveness endpoints, use defaults if not given
+func HealthPaths(paths ...string) (ready, live string) {
+	ready, live = gkgrace.DefaultReadyPath, gkgrace.DefaultLivePath
+	if len(paths) > 0 && paths[0] != "" {
+		ready = paths[0]
+	}
+	if len(paths) > 1 && paths[1] != "" {
+		live = paths[1]
+	}
+	return
+}
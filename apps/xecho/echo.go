@@ -1,16 +1,19 @@
 package xecho
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/color"
 	"github.com/labstack/gommon/log"
+	"github.com/moqsien/gkgrace"
 	"github.com/moqsien/gkgrace/apps/base"
-	"github.com/moqsien/processes/logger"
 )
 
 const (
@@ -28,6 +31,8 @@ const (
  `
 )
 
+// graceful wrapper for echo
+// implementation of IApp
 type EchoGrace struct {
 	*echo.Echo
 	*base.Base
@@ -49,10 +54,25 @@ type IEVisitor interface {
 
 func (that *EchoGrace) ExtraMethod(e IEVisitor) {
 	if err := e.ExtraMethod(that); err != nil {
-		logger.Errorf("'ExtraMethod' errored! err: ", err.Error())
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
 	}
 }
 
+// UseHealth add readiness and liveness endpoints, paths: [readyPath, livePath]
+func (that *EchoGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	that.GET(ready, func(c echo.Context) error {
+		return c.String(that.Readiness())
+	})
+	that.GET(live, func(c echo.Context) error {
+		return c.String(that.Liveness())
+	})
+}
+
+func (that *EchoGrace) Name() string {
+	return "xecho@" + that.GetAddr().String()
+}
+
 func (that *EchoGrace) Run(certs ...string) error {
 	if that.Grace == nil {
 		panic("Grace is not set! Please use SetGrace to set it.")
@@ -61,42 +81,52 @@ func (that *EchoGrace) Run(certs ...string) error {
 	if ln == nil {
 		return fmt.Errorf("Cannot get a listener! ")
 	}
-	that.SetListener(ln)
 
 	that.Echo.HideBanner = true
 	that.Echo.Server.Addr = that.GetAddr().Addr()
 
-	if len(certs) == 0 {
-		that.startupMutex.Lock()
-		if err := that.configServer(that.Echo.Server); err != nil {
-			that.startupMutex.Unlock()
-			return err
-		}
+	protos := []string{"h2", "http/1.1"}
+	if that.Echo.DisableHTTP2 {
+		protos = protos[1:]
+	}
+	config, err := that.NewTLSConfig(certs, protos...)
+	if err != nil {
+		return err
+	}
+	that.startupMutex.Lock()
+	s := that.Echo.Server
+	if config != nil {
+		s = that.Echo.TLSServer
+		s.Addr = that.GetAddr().Addr()
+		s.TLSConfig = config
+	}
+	if err := that.configServer(s, ln); err != nil {
 		that.startupMutex.Unlock()
-		return that.Echo.Server.Serve(that.Echo.Listener)
-	} else if len(certs) > 1 {
-		that.startupMutex.Lock()
-		s := that.Echo.TLSServer
-		s.TLSConfig = new(tls.Config)
-		s.TLSConfig.Certificates = make([]tls.Certificate, 1)
-		cert, err := tls.LoadX509KeyPair(certs[0], certs[1])
-		if err != nil {
-			that.startupMutex.Unlock()
-			return err
-		}
-		s.TLSConfig.Certificates[0] = cert
-		that.configTLS()
-		if err := that.configServer(s); err != nil {
-			that.startupMutex.Unlock()
-			return err
+		return err
+	}
+	raw := ln
+	if config != nil {
+		ln = that.Echo.TLSListener
+	} else {
+		ln = that.Echo.Listener
+	}
+	that.startupMutex.Unlock()
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
 		}
-		that.startupMutex.Unlock()
-		return s.Serve(that.Echo.TLSListener)
+		return s.Serve(ln)
+	}, protos...); err != nil {
+		return err
+	}
+	that.SetListener(raw)
+	if err := s.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
 	return nil
 }
 
-func (that *EchoGrace) configServer(s *http.Server) error {
+func (that *EchoGrace) configServer(s *http.Server, ln net.Listener) error {
 	// Setup
 	that.colorer.SetOutput(that.Echo.Logger.Output())
 	s.ErrorLog = that.Echo.StdLogger
@@ -111,7 +141,7 @@ func (that *EchoGrace) configServer(s *http.Server) error {
 
 	if s.TLSConfig == nil {
 		if that.Echo.Listener == nil {
-			that.Echo.Listener = that.FetchListener()
+			that.Echo.Listener = ln
 		}
 		if !that.Echo.HidePort {
 			that.colorer.Printf("⇨ http server started on %s\n", that.colorer.Green(that.Echo.Listener.Addr()))
@@ -120,7 +150,7 @@ func (that *EchoGrace) configServer(s *http.Server) error {
 	}
 
 	if that.Echo.TLSListener == nil {
-		that.Echo.TLSListener = tls.NewListener(that.FetchListener(), s.TLSConfig)
+		that.Echo.TLSListener = tls.NewListener(ln, s.TLSConfig)
 	}
 	if !that.Echo.HidePort {
 		that.colorer.Printf("⇨ https server started on %s\n", that.colorer.Green(that.TLSListener.Addr()))
@@ -128,10 +158,22 @@ func (that *EchoGrace) configServer(s *http.Server) error {
 	return nil
 }
 
-func (that *EchoGrace) configTLS() {
-	s := that.Echo.TLSServer
-	s.Addr = that.GetAddr().Addr()
-	if !that.Echo.DisableHTTP2 {
-		s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, "h2")
+// Execute start serving in background, it returns once the app is listening
+func (that *EchoGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting and wait for in-flight requests within MaxWaitTime of Grace, it can be used as an exit hook
+func (that *EchoGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := that.Echo.Shutdown(ctx); err != nil {
+		that.Log().Warn("shutdown timeout, connections are closed", "app", that.Name(), "err", err)
+		return that.Echo.Close()
+	}
+	return nil
 }
@@ -0,0 +1,126 @@
+package xfasthttp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/moqsien/gkgrace"
+	"github.com/moqsien/gkgrace/apps/base"
+	"github.com/valyala/fasthttp"
+)
+
+// graceful wrapper for fasthttp
+// implementation of IApp
+type FasthttpGrace struct {
+	*fasthttp.Server
+	*base.Base
+}
+
+// New wrap a fasthttp.RequestHandler, such as fasthttp/router
+func New(h fasthttp.RequestHandler) *FasthttpGrace {
+	return NewServer(&fasthttp.Server{Handler: h})
+}
+
+// NewServer wrap a preconfigured fasthttp.Server, its timeouts and TLS config are kept
+func NewServer(srv *fasthttp.Server) *FasthttpGrace {
+	return &FasthttpGrace{
+		Server: srv,
+		Base:   base.New(),
+	}
+}
+
+type IFVisitor interface {
+	ExtraMethod(that *FasthttpGrace) error
+}
+
+// ExtraMethod visitor pattern, add extra method for FasthttpGrace.
+func (that *FasthttpGrace) ExtraMethod(f IFVisitor) {
+	if err := f.ExtraMethod(that); err != nil {
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
+	}
+}
+
+// UseHealth add readiness and liveness endpoints in front of Handler, paths: [readyPath, livePath]
+func (that *FasthttpGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	next := that.Handler
+	that.Handler = func(ctx *fasthttp.RequestCtx) {
+		switch string(ctx.Path()) {
+		case ready:
+			code, msg := that.Readiness()
+			ctx.SetStatusCode(code)
+			ctx.SetBodyString(msg)
+		case live:
+			code, msg := that.Liveness()
+			ctx.SetStatusCode(code)
+			ctx.SetBodyString(msg)
+		default:
+			next(ctx)
+		}
+	}
+}
+
+func (that *FasthttpGrace) Name() string {
+	return "xfasthttp@" + that.GetAddr().String()
+}
+
+// Conns return number of open connections
+func (that *FasthttpGrace) Conns() int32 {
+	return that.Server.GetOpenConnectionsCount()
+}
+
+func (that *FasthttpGrace) Run(certs ...string) error {
+	if that.Grace == nil {
+		panic("Grace is not set! Please use SetGrace to set it.")
+	}
+	ln := that.Grace.GetListener(that)
+	if ln == nil {
+		return fmt.Errorf("Cannot get a listener! ")
+	}
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs, "http/1.1")
+	if err != nil {
+		return err
+	}
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
+		}
+		return that.Server.Serve(ln)
+	}, "http/1.1"); err != nil {
+		return err
+	}
+	that.SetListener(ln)
+	if config != nil {
+		// TLS
+		return that.Server.Serve(tls.NewListener(ln, config))
+	}
+	if tc := that.Server.TLSConfig; tc != nil && (len(tc.Certificates) > 0 || tc.GetCertificate != nil) {
+		// TLS configured in fasthttp.Server
+		return that.Server.Serve(tls.NewListener(ln, tc.Clone()))
+	}
+	// no TLS
+	return that.Server.Serve(ln)
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *FasthttpGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting and wait for open connections within MaxWaitTime of Grace, it can be used as an exit hook
+func (that *FasthttpGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := that.Server.ShutdownWithContext(ctx); err != nil {
+		that.Log().Warn("shutdown timeout", "app", that.Name(), "conns", that.Conns(), "err", err)
+		return err
+	}
+	return nil
+}
@@ -1,14 +1,18 @@
 package xfiber
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/moqsien/gkgrace"
 	"github.com/moqsien/gkgrace/apps/base"
-	"github.com/moqsien/processes/logger"
 )
 
+// graceful wrapper for fiber
+// implementation of IApp
 type FiberGrace struct {
 	*fiber.App
 	*base.Base
@@ -27,10 +31,28 @@ type IFVistor interface {
 
 func (that *FiberGrace) ExtraMethod(f IFVistor) {
 	if err := f.ExtraMethod(that); err != nil {
-		logger.Errorf("'ExtraMethod' errored! err: ", err.Error())
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
 	}
 }
 
+// UseHealth add readiness and liveness endpoints, paths: [readyPath, livePath]
+func (that *FiberGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	that.Get(ready, func(c *fiber.Ctx) error {
+		code, text := that.Readiness()
+		return c.Status(code).SendString(text)
+	})
+	that.Get(live, func(c *fiber.Ctx) error {
+		code, text := that.Liveness()
+		return c.Status(code).SendString(text)
+	})
+}
+
+// Name return name of the app, Name of fiber.App is still available as that.App.Name
+func (that *FiberGrace) Name() string {
+	return "xfiber@" + that.GetAddr().String()
+}
+
 func (that *FiberGrace) Run(certs ...string) error {
 	if that.Grace == nil {
 		panic("Grace is not set! Please use SetGrace to set it.")
@@ -39,19 +61,53 @@ func (that *FiberGrace) Run(certs ...string) error {
 	if ln == nil {
 		return fmt.Errorf("Cannot get a listener! ")
 	}
-	that.SetListener(ln)
-	if len(certs) > 1 {
-		cert, err := tls.LoadX509KeyPair(certs[0], certs[1])
-		if err != nil {
-			logger.Errorf("tls: cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certs[0], certs[1], err.Error())
-			return err
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs, "http/1.1")
+	if err != nil {
+		that.Log().Error("tls: cannot configure TLS", "err", err)
+		return err
+	}
+	if config != nil {
+		handler, getCertificate := &fiber.TLSHandler{}, config.GetCertificate
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			handler.GetClientInfo(hello)
+			return getCertificate(hello)
 		}
-		config := &tls.Config{
-			MinVersion:     tls.VersionTLS12,
-			Certificates:   []tls.Certificate{cert},
-			GetCertificate: (&fiber.TLSHandler{}).GetClientInfo,
+	}
+	// App.Listener prepares the App on every call, routes are built once and listeners share its server
+	that.App.Handler()
+	server := that.App.Server()
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
 		}
+		return server.Serve(ln)
+	}, "http/1.1"); err != nil {
+		return err
+	}
+	that.SetListener(ln)
+	if config != nil {
 		ln = tls.NewListener(ln, config)
 	}
-	return that.App.Listener(ln)
+	return server.Serve(ln)
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *FiberGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting and wait for open connections within MaxWaitTime of Grace, it can be used as an exit hook
+func (that *FiberGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := that.App.Server().ShutdownWithContext(ctx); err != nil {
+		that.Log().Warn("shutdown timeout", "app", that.Name(), "err", err)
+		return err
+	}
+	return nil
 }
@@ -1,25 +1,31 @@
 package xgin
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/moqsien/gkgrace"
 	"github.com/moqsien/gkgrace/apps/base"
-	"github.com/moqsien/processes/logger"
 )
 
 // graceful wrapper for gin
-// implementation of IAppBase
+// implementation of IApp
 type GinGrace struct {
 	*gin.Engine
 	*base.Base
+	server *http.Server
 }
 
 func New() *GinGrace {
 	return &GinGrace{
 		Engine: gin.New(),
 		Base:   base.New(),
+		server: &http.Server{},
 	}
 }
 
@@ -30,10 +36,25 @@ type IGVisitor interface {
 // ExtraMethod visitor pattern, add extra method for GinGrace.
 func (that *GinGrace) ExtraMethod(g IGVisitor) {
 	if err := g.ExtraMethod(that); err != nil {
-		logger.Errorf("'ExtraMethod' errored! err: ", err.Error())
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
 	}
 }
 
+// UseHealth add readiness and liveness endpoints, paths: [readyPath, livePath]
+func (that *GinGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	that.GET(ready, func(c *gin.Context) {
+		c.String(that.Readiness())
+	})
+	that.GET(live, func(c *gin.Context) {
+		c.String(that.Liveness())
+	})
+}
+
+func (that *GinGrace) Name() string {
+	return "xgin@" + that.GetAddr().String()
+}
+
 func (that *GinGrace) Run(certs ...string) error {
 	if that.Grace == nil {
 		panic("Grace is not set! Please use SetGrace to set it.")
@@ -42,12 +63,55 @@ func (that *GinGrace) Run(certs ...string) error {
 	if ln == nil {
 		return fmt.Errorf("Cannot get a listener! ")
 	}
+	srv := that.server
+	srv.Addr, srv.Handler = that.Address.Addr(), that
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs, "h2", "http/1.1")
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		// endpoints share srv, it is configured before they start
+		srv.TLSConfig = config
+	}
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
+		}
+		return srv.Serve(ln)
+	}, "h2", "http/1.1"); err != nil {
+		return err
+	}
 	that.SetListener(ln)
-	srv := &http.Server{Addr: that.Address.Addr(), Handler: that}
-	if len(certs) > 1 {
+	if config != nil {
 		// TLS
-		return srv.ServeTLS(ln, certs[0], certs[1]) // listener, certFile, keyFile
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		// no TLS
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *GinGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting and wait for in-flight requests within MaxWaitTime of Grace, it can be used as an exit hook
+func (that *GinGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := that.server.Shutdown(ctx); err != nil {
+		that.Log().Warn("shutdown timeout, connections are closed", "app", that.Name(), "err", err)
+		return that.server.Close()
 	}
-	// no TLS
-	return srv.Serve(ln)
+	return nil
 }
@@ -0,0 +1,143 @@
+package xgrpc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/moqsien/gkgrace"
+	"github.com/moqsien/gkgrace/apps/base"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// graceful wrapper for grpc
+// implementation of IApp
+type GrpcGrace struct {
+	*grpc.Server
+	*base.Base
+	Health   *health.Server // standard health service, set by UseHealth
+	services []string
+	cancel   func()
+}
+
+func New(opts ...grpc.ServerOption) *GrpcGrace {
+	return NewServer(grpc.NewServer(opts...))
+}
+
+// NewServer wrap a preconfigured grpc.Server
+func NewServer(srv *grpc.Server) *GrpcGrace {
+	return &GrpcGrace{
+		Server: srv,
+		Base:   base.New(),
+	}
+}
+
+type IGVisitor interface {
+	ExtraMethod(that *GrpcGrace) error
+}
+
+// ExtraMethod visitor pattern, add extra method for GrpcGrace.
+func (that *GrpcGrace) ExtraMethod(g IGVisitor) {
+	if err := g.ExtraMethod(that); err != nil {
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
+	}
+}
+
+// UseHealth register the standard health service, status of the server ("") and services follows Grace:
+// SERVING while running, NOT_SERVING during lame-duck, reload and drain
+func (that *GrpcGrace) UseHealth(services ...string) {
+	if that.Health == nil {
+		that.Health = health.NewServer()
+		grpc_health_v1.RegisterHealthServer(that.Server, that.Health)
+	}
+	that.services = append([]string{""}, services...)
+	that.setServing(false)
+}
+
+func (that *GrpcGrace) setServing(serving bool) {
+	status := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	for _, s := range that.services {
+		that.Health.SetServingStatus(s, status)
+	}
+}
+
+// watchStatus keep health status in line with Grace
+func (that *GrpcGrace) watchStatus() {
+	if that.Health == nil || that.cancel != nil {
+		return
+	}
+	that.cancel = that.Grace.Subscribe(func(t gkgrace.Transition) {
+		that.setServing(t.To == gkgrace.GraceRunning)
+	})
+	that.setServing(that.Grace.Status.Is(gkgrace.GraceRunning))
+}
+
+func (that *GrpcGrace) Name() string {
+	return "xgrpc@" + that.GetAddr().String()
+}
+
+func (that *GrpcGrace) Run(certs ...string) error {
+	if that.Grace == nil {
+		panic("Grace is not set! Please use SetGrace to set it.")
+	}
+	ln := that.Grace.GetListener(that)
+	if ln == nil {
+		return fmt.Errorf("Cannot get a listener! ")
+	}
+	// certs: certFile, keyFile, [certFile, keyFile]..., or use grpc.Creds when creating the server
+	config, err := that.NewTLSConfig(certs, "h2")
+	if err != nil {
+		return err
+	}
+	that.watchStatus()
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
+		}
+		return that.Server.Serve(ln)
+	}, "h2"); err != nil {
+		return err
+	}
+	that.SetListener(ln)
+	if config != nil {
+		// TLS
+		ln = tls.NewListener(ln, config)
+	}
+	return that.Server.Serve(ln)
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *GrpcGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop gracefully within MaxWaitTime of Grace, then close all connections, it can be used as an exit hook
+func (that *GrpcGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
+	}
+	if that.Health != nil {
+		that.Health.Shutdown()
+	}
+	done := make(chan struct{})
+	go func() {
+		that.Server.GracefulStop()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		that.Log().Warn("graceful stop timeout, connections are closed", "app", that.Name())
+		that.Server.Stop()
+	}
+	return nil
+}
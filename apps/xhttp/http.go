@@ -0,0 +1,142 @@
+package xhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/moqsien/gkgrace"
+	"github.com/moqsien/gkgrace/apps/base"
+)
+
+// graceful wrapper for net/http
+// implementation of IApp
+type HttpGrace struct {
+	*http.Server
+	*base.Base
+}
+
+// New wrap a http.Handler, such as http.ServeMux, chi or gorilla/mux
+func New(h http.Handler) *HttpGrace {
+	return NewServer(&http.Server{Handler: h})
+}
+
+// NewServer wrap a preconfigured http.Server, its timeouts and TLS config are kept
+func NewServer(srv *http.Server) *HttpGrace {
+	that := &HttpGrace{
+		Server: srv,
+		Base:   base.New(),
+	}
+	if host, port, err := net.SplitHostPort(srv.Addr); err == nil {
+		p, _ := strconv.Atoi(port)
+		that.SetAddr(&gkgrace.Address{Network: "tcp", Host: host, Port: p})
+	}
+	return that
+}
+
+type IHVisitor interface {
+	ExtraMethod(that *HttpGrace) error
+}
+
+// ExtraMethod visitor pattern, add extra method for HttpGrace.
+func (that *HttpGrace) ExtraMethod(h IHVisitor) {
+	if err := h.ExtraMethod(that); err != nil {
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
+	}
+}
+
+// UseHealth add readiness and liveness endpoints in front of Handler, paths: [readyPath, livePath]
+func (that *HttpGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	next := that.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	that.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case ready:
+			code, msg := that.Readiness()
+			w.WriteHeader(code)
+			w.Write([]byte(msg))
+		case live:
+			code, msg := that.Liveness()
+			w.WriteHeader(code)
+			w.Write([]byte(msg))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (that *HttpGrace) Name() string {
+	return "xhttp@" + that.GetAddr().String()
+}
+
+func (that *HttpGrace) Run(certs ...string) error {
+	if that.Grace == nil {
+		panic("Grace is not set! Please use SetGrace to set it.")
+	}
+	ln := that.Grace.GetListener(that)
+	if ln == nil {
+		return fmt.Errorf("Cannot get a listener! ")
+	}
+	if that.Server.Addr == "" {
+		that.Server.Addr = that.Address.Addr()
+	}
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs, "h2", "http/1.1")
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		// endpoints share Server, it is configured before they start
+		that.Server.TLSConfig = config
+	}
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
+		}
+		return that.Server.Serve(ln)
+	}, "h2", "http/1.1"); err != nil {
+		return err
+	}
+	that.SetListener(ln)
+	if config != nil {
+		// TLS
+		err = that.Server.ServeTLS(ln, "", "")
+	} else if tc := that.Server.TLSConfig; tc != nil && (len(tc.Certificates) > 0 || tc.GetCertificate != nil) {
+		// TLS configured in http.Server
+		err = that.Server.ServeTLS(ln, "", "")
+	} else {
+		// no TLS
+		err = that.Server.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *HttpGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting and wait for in-flight requests within MaxWaitTime of Grace, it can be used as an exit hook
+func (that *HttpGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := that.Server.Shutdown(ctx); err != nil {
+		that.Log().Warn("shutdown timeout, connections are closed", "app", that.Name(), "err", err)
+		return that.Server.Close()
+	}
+	return nil
+}
@@ -1,15 +1,19 @@
 package xiris
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/core/host"
+	"github.com/moqsien/gkgrace"
 	"github.com/moqsien/gkgrace/apps/base"
-	"github.com/moqsien/processes/logger"
 )
 
+// graceful wrapper for iris
+// implementation of IApp
 type IrisGrace struct {
 	*iris.Application
 	*base.Base
@@ -30,7 +34,7 @@ type IRVisitor interface {
 
 func (that *IrisGrace) ExtraMethod(r IRVisitor) {
 	if err := r.ExtraMethod(that); err != nil {
-		logger.Errorf("'ExtraMethod' errored! err: ", err.Error())
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
 	}
 }
 
@@ -42,6 +46,25 @@ func (that *IrisGrace) SetConfigs(cnfs ...iris.Configurator) {
 	that.configs = append(that.configs, cnfs...)
 }
 
+// UseHealth add readiness and liveness endpoints, paths: [readyPath, livePath]
+func (that *IrisGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	that.Get(ready, func(ctx iris.Context) {
+		code, text := that.Readiness()
+		ctx.StatusCode(code)
+		ctx.WriteString(text)
+	})
+	that.Get(live, func(ctx iris.Context) {
+		code, text := that.Liveness()
+		ctx.StatusCode(code)
+		ctx.WriteString(text)
+	})
+}
+
+func (that *IrisGrace) Name() string {
+	return "xiris@" + that.GetAddr().String()
+}
+
 func (that *IrisGrace) Run(certs ...string) error {
 	if that.Grace == nil {
 		panic("Grace is not set! Please use SetGrace to set it.")
@@ -50,20 +73,45 @@ func (that *IrisGrace) Run(certs ...string) error {
 	if ln == nil {
 		return fmt.Errorf("Cannot get a listener! ")
 	}
-	that.SetListener(ln)
-	if len(certs) > 1 {
-		cert, err := tls.LoadX509KeyPair(certs[0], certs[1])
-		if err != nil {
-			logger.Errorf("tls: cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certs[0], certs[1], err.Error())
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs, "h2", "http/1.1")
+	if err != nil {
+		that.Log().Error("tls: cannot configure TLS", "err", err)
+		return err
+	}
+	raw := ln
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	runner := func(app *iris.Application) error {
+		// hosts of endpoints are created after the app is built
+		if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+			if config != nil {
+				ln = tls.NewListener(ln, config)
+			}
+			return iris.Listener(ln, that.hostConfigs...)(app)
+		}, "h2", "http/1.1"); err != nil {
 			return err
 		}
-		config := &tls.Config{
-			MinVersion:   tls.VersionTLS12,
-			Certificates: []tls.Certificate{cert},
-			NextProtos:   []string{"h2", "http/1.1"},
-		}
-		ln = tls.NewListener(ln, config)
+		that.SetListener(raw)
+		return iris.Listener(ln, that.hostConfigs...)(app)
+	}
+	configs := append([]iris.Configurator{iris.WithoutServerError(iris.ErrServerClosed)}, that.configs...)
+	return that.Application.Run(runner, configs...)
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *IrisGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting and wait for in-flight requests within MaxWaitTime of Grace, it can be used as an exit hook
+func (that *IrisGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
 	}
-	runner := iris.Listener(ln, that.hostConfigs...)
-	return that.Application.Run(runner, that.configs...)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return that.Application.Shutdown(ctx)
 }
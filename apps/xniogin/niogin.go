@@ -1,13 +1,17 @@
 package xniogn
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 
+	"github.com/gin-gonic/gin"
 	"github.com/moqsien/gkgrace/apps/base"
 	"github.com/moqsien/niogin/httpserver"
-	"github.com/moqsien/processes/logger"
 )
 
+// graceful wrapper for niogin
+// implementation of IApp
 type NioGrace struct {
 	*httpserver.Engine
 	*base.Base
@@ -26,10 +30,25 @@ type INVistitor interface {
 
 func (that *NioGrace) ExtraMethod(n INVistitor) {
 	if err := n.ExtraMethod(that); err != nil {
-		logger.Errorf("'ExtraMethod' errored! err: ", err.Error())
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
 	}
 }
 
+// UseHealth add readiness and liveness endpoints, paths: [readyPath, livePath]
+func (that *NioGrace) UseHealth(paths ...string) {
+	ready, live := base.HealthPaths(paths...)
+	that.GET(ready, func(c *gin.Context) {
+		c.String(that.Readiness())
+	})
+	that.GET(live, func(c *gin.Context) {
+		c.String(that.Liveness())
+	})
+}
+
+func (that *NioGrace) Name() string {
+	return "xniogin@" + that.GetAddr().String()
+}
+
 func (that *NioGrace) Run(certs ...string) error {
 	if that.Grace == nil {
 		panic("Grace is not set! Please use SetGrace to set it.")
@@ -38,10 +57,52 @@ func (that *NioGrace) Run(certs ...string) error {
 	if ln == nil {
 		return fmt.Errorf("Cannot get a listener! ")
 	}
-	that.SetListener(ln)
 	that.Engine.SetPoll(true)
-	if len(certs) > 1 {
-		return that.Engine.ServeTLS(ln, certs[0], certs[1])
+	for _, e := range that.Endpoints {
+		if e.TLS != nil {
+			// Engine has only one TLSConfig
+			return fmt.Errorf("TLS of %s is not supported, niogin serves TLS on the first address only", e.Address.String())
+		}
+	}
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs, "http/1.1")
+	if err != nil {
+		return err
+	}
+	if err := that.ServeEndpoints(func(ln net.Listener, _ *tls.Config) error {
+		return that.Engine.Serve(unwrap(ln))
+	}); err != nil {
+		return err
+	}
+	that.SetListener(ln)
+	ln = unwrap(ln)
+	if config != nil {
+		that.Engine.TLSConfig = config
+		return that.Engine.ServeTLS(ln, "", "")
 	}
 	return that.Engine.Serve(ln)
 }
+
+// Execute start serving in background, it returns once the app is listening
+func (that *NioGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting, Engine does not track connections, they are closed when the process exits
+func (that *NioGrace) Exit() error {
+	for _, ln := range that.FetchListeners() {
+		ln.Close()
+	}
+	return nil
+}
+
+// unwrap return the original listener under every wrapper, epoll needs it
+func unwrap(ln net.Listener) net.Listener {
+	for {
+		u, ok := ln.(interface{ Unwrap() net.Listener })
+		if !ok {
+			return ln
+		}
+		ln = u.Unwrap()
+	}
+}
@@ -0,0 +1,263 @@
+package xtcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moqsien/gkgrace"
+	"github.com/moqsien/gkgrace/apps/base"
+)
+
+// Handler serves a connection, ctx is cancelled when the app starts draining
+// the connection is closed after Handler returns
+type Handler func(ctx context.Context, c net.Conn)
+
+// graceful server for raw tcp and unix stream protocols
+// implementation of IApp
+type TcpGrace struct {
+	*base.Base
+	Handler     Handler
+	IdleTimeout time.Duration // close connections without reads or writes for this long, 0 to disable
+	ReadTimeout time.Duration // deadline of every Read, 0 to disable
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	conns       map[*trackedConn]struct{}
+	wg          sync.WaitGroup
+	forceClosed int64
+}
+
+func New(h Handler) *TcpGrace {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TcpGrace{
+		Base:    base.New(),
+		Handler: h,
+		ctx:     ctx,
+		cancel:  cancel,
+		conns:   make(map[*trackedConn]struct{}),
+	}
+}
+
+type ITVisitor interface {
+	ExtraMethod(that *TcpGrace) error
+}
+
+// ExtraMethod visitor pattern, add extra method for TcpGrace.
+func (that *TcpGrace) ExtraMethod(t ITVisitor) {
+	if err := t.ExtraMethod(that); err != nil {
+		that.Log().Error("'ExtraMethod' errored!", "err", err)
+	}
+}
+
+func (that *TcpGrace) Name() string {
+	return "xtcp@" + that.GetAddr().String()
+}
+
+// Conns return number of live connections
+func (that *TcpGrace) Conns() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return len(that.conns)
+}
+
+// ForceClosed return number of connections closed at the deadline of draining
+func (that *TcpGrace) ForceClosed() int64 {
+	return atomic.LoadInt64(&that.forceClosed)
+}
+
+func (that *TcpGrace) Run(certs ...string) error {
+	if that.Grace == nil {
+		panic("Grace is not set! Please use SetGrace to set it.")
+	}
+	if that.Handler == nil {
+		return fmt.Errorf("Handler is not set! ")
+	}
+	ln := that.Grace.GetListener(that)
+	if ln == nil {
+		return fmt.Errorf("Cannot get a listener! ")
+	}
+	// certs: certFile, keyFile, [certFile, keyFile]...
+	config, err := that.NewTLSConfig(certs)
+	if err != nil {
+		return err
+	}
+	if that.IdleTimeout > 0 {
+		go that.closeIdle()
+	}
+	if err := that.ServeEndpoints(func(ln net.Listener, config *tls.Config) error {
+		if config != nil {
+			ln = tls.NewListener(ln, config)
+		}
+		return that.accept(ln)
+	}); err != nil {
+		return err
+	}
+	that.SetListener(ln)
+	if config != nil {
+		// TLS
+		ln = tls.NewListener(ln, config)
+	}
+	return that.accept(ln)
+}
+
+// accept serve connections of a listener until it is closed
+func (that *TcpGrace) accept(ln net.Listener) error {
+	var delay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if that.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// backoff like http.Server
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				that.Log().Warn("accept error, retrying", "app", that.Name(), "delay", delay, "err", err)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		that.serve(c)
+	}
+}
+
+func (that *TcpGrace) serve(c net.Conn) {
+	tc := &trackedConn{Conn: c, readTimeout: that.ReadTimeout}
+	tc.touch()
+	that.mu.Lock()
+	if that.ctx.Err() != nil {
+		that.mu.Unlock()
+		c.Close()
+		return
+	}
+	that.conns[tc] = struct{}{}
+	that.wg.Add(1)
+	that.mu.Unlock()
+	go func() {
+		defer that.wg.Done()
+		defer func() {
+			that.mu.Lock()
+			delete(that.conns, tc)
+			that.mu.Unlock()
+			tc.Close()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				that.Log().Error("connection handler panicked", "app", that.Name(), "remote", c.RemoteAddr(), "panic", r)
+			}
+		}()
+		that.Handler(that.ctx, tc)
+	}()
+}
+
+// closeIdle close connections idle longer than IdleTimeout
+func (that *TcpGrace) closeIdle() {
+	interval := that.IdleTimeout / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-that.ctx.Done():
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(-that.IdleTimeout).UnixNano()
+			that.mu.Lock()
+			for c := range that.conns {
+				if atomic.LoadInt64(&c.lastActive) < deadline {
+					c.Close()
+				}
+			}
+			that.mu.Unlock()
+		}
+	}
+}
+
+// Execute start serving in background, it returns once the app is listening
+func (that *TcpGrace) Execute() error {
+	return that.Base.Start(that.Name(), that.Run)
+}
+
+// Exit stop accepting, cancel contexts of handlers and wait for them within MaxWaitTime of Grace,
+// connections still open at the deadline are force-closed, it can be used as an exit hook
+func (that *TcpGrace) Exit() error {
+	timeout := gkgrace.DefualtMaxWaitTime
+	if that.Grace != nil {
+		timeout = that.Grace.MaxWaitTime
+	}
+	that.mu.Lock()
+	that.cancel()
+	that.mu.Unlock()
+	for _, ln := range that.FetchListeners() {
+		ln.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		that.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		that.mu.Lock()
+		n := len(that.conns)
+		for c := range that.conns {
+			c.Close()
+		}
+		that.mu.Unlock()
+		atomic.AddInt64(&that.forceClosed, int64(n))
+		that.Log().Warn("connections force-closed at deadline", "app", that.Name(), "count", n)
+	}
+	return nil
+}
+
+// trackedConn records activity and applies read deadlines
+type trackedConn struct {
+	net.Conn
+	readTimeout time.Duration
+	lastActive  int64 // unix nano
+}
+
+func (that *trackedConn) touch() {
+	atomic.StoreInt64(&that.lastActive, time.Now().UnixNano())
+}
+
+func (that *trackedConn) Read(b []byte) (int, error) {
+	if that.readTimeout > 0 {
+		that.Conn.SetReadDeadline(time.Now().Add(that.readTimeout))
+	}
+	n, err := that.Conn.Read(b)
+	if n > 0 {
+		that.touch()
+	}
+	return n, err
+}
+
+func (that *trackedConn) Write(b []byte) (int, error) {
+	n, err := that.Conn.Write(b)
+	if n > 0 {
+		that.touch()
+	}
+	return n, err
+}
+
+// Unwrap return the original connection
+func (that *trackedConn) Unwrap() net.Conn {
+	return that.Conn
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moqsien/gkgrace"
+	"github.com/moqsien/gkgrace/apps/xgin"
+)
+
+func main() {
+	path := "gkgrace.yaml"
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+	g, err := gkgrace.NewFromConfig(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// reload when the binary is replaced or tmp/restart.txt is touched, config changes are reloaded in place
+	g.Watch()
+
+	gin.SetMode(gin.ReleaseMode)
+	app := xgin.New()
+	app.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "OK! Hello from %d!", os.Getpid())
+	})
+	// addresses and TLS of "api" in config
+	if err := app.Configure(g.Config.ListenersOf("api")...); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// reload: kill -USR1 $(cat /tmp/gkgrace-example.pid)
+	// reload config in place: kill -HUP $(cat /tmp/gkgrace-example.pid), listeners changed cause a reload
+	if err := g.RunApps(context.Background(), app); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
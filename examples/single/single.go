@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,7 +14,6 @@ import (
 )
 
 func run() {
-	grace := gkgrace.New()
 	gin.SetMode(gin.ReleaseMode)
 	app := xgin.New()
 	app.GET("/", func(c *gin.Context) {
@@ -24,8 +24,6 @@ func run() {
 		Host:    "0.0.0.0",
 		Port:    8080,
 	})
-	grace.Register(app)
-	go app.Run()
 
 	app1 := xgin.New()
 	app1.GET("/", func(c *gin.Context) {
@@ -36,10 +34,12 @@ func run() {
 		Host:    "0.0.0.0",
 		Port:    8081,
 	})
-	grace.Register(app1)
-	go app1.Run()
 
-	grace.Wait()
+	// register, start and wait, all apps are stopped if any of them fails
+	if err := gkgrace.Run(context.Background(), app, app1); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 func main() {
@@ -16,10 +16,22 @@ type GraceStatus int
 
 func (that GraceStatus) String() (r string) {
 	switch that {
-	case 1:
+	case GraceExiting:
 		r = "Exiting"
-	case 2:
+	case GraceReloading:
 		r = "Reloading"
+	case GraceStarting:
+		r = "Starting"
+	case GraceRunning:
+		r = "Running"
+	case GraceDraining:
+		r = "Draining"
+	case GraceHandedOff:
+		r = "HandedOff"
+	case GraceStopped:
+		r = "Stopped"
+	case GraceLameDuck:
+		r = "LameDuck"
 	default:
 		r = "Unknown"
 	}
@@ -28,8 +40,14 @@ func (that GraceStatus) String() (r string) {
 
 // names of environment variables
 const (
-	GraceEnvIsChild     = "GRACE_IS_CHILD"      // to mark the child process by "true"
-	GraceEnvFdsInSingle = "GRACE_FDS_IN_SINGLE" // single-process mode, add fds to env
+	GraceEnvIsChild     = "GRACE_IS_CHILD"            // to mark the child process by "true"
+	GraceEnvFdsInSingle = "GRACE_FDS_IN_SINGLE"       // single-process mode, add fds to env
+	GraceEnvGeneration  = "GRACE_GENERATION"          // how many times the process has been reloaded
+	GraceEnvLogLevel    = "GRACE_LOG_LEVEL"           // log level inherited by child processes
+	GraceEnvManifest    = "GRACE_HANDOFF_MANIFEST"    // JSON handoff manifest
+	GraceEnvManifestFd  = "GRACE_HANDOFF_MANIFEST_FD" // fd to read JSON handoff manifest from
+	GraceEnvControlSock = "GRACE_CONTROL_SOCKET"      // path of unix control socket, processes upgrade through it
+	GraceEnvSupervised  = "GRACE_SUPERVISED"          // to mark worker processes started by a supervisor
 )
 
 // offset for extrafiles
@@ -43,10 +61,18 @@ const (
 	GraceUnKnown   GraceStatus = 0
 	GraceExiting   GraceStatus = 1
 	GraceReloading GraceStatus = 2
+	GraceStarting  GraceStatus = 3 // listeners are being registered
+	GraceRunning   GraceStatus = 4 // serving and waiting for signals
+	GraceDraining  GraceStatus = 5 // stop accepting, waiting for in-flight requests
+	GraceHandedOff GraceStatus = 6 // a child process has taken over the listeners
+	GraceStopped   GraceStatus = 7 // exit hooks finished, process is about to exit
+	GraceLameDuck  GraceStatus = 8 // still serving but reporting not-ready before draining
 )
 
 var IsChildProcess = genv.GetVar(GraceEnvIsChild, false).Bool()
 
+var CurrentGeneration = genv.GetVar(GraceEnvGeneration, 0).Int()
+
 var WorkingDir, _ = os.Getwd()
 
 /*
@@ -56,3 +82,9 @@ type IAddress interface {
 	GetAddr() *Address
 	SetGrace(g *Grace)
 }
+
+// IAddresses is implemented by apps serving on more than one address
+type IAddresses interface {
+	IAddress
+	GetAddrs() []*Address // all addresses, including GetAddr()
+}
@@ -1,13 +1,25 @@
 package gkgrace
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
 
 // Adress
 type Address struct {
-	Network string // "tcp", "udp" or "unix"
-	Host    string // host ip, "0.0.0.0" by default
-	Port    int    // port
-	Sock    string // unix domain socket file path if Network is "unix"
+	Network   string        // "tcp", "udp" or "unix"
+	Host      string        // host ip, "0.0.0.0" by default
+	Port      int           // port
+	Sock      string        // unix domain socket file path if Network is "unix"
+	ReusePort bool          // set SO_REUSEPORT on new listeners
+	KeepAlive time.Duration // TCP keep-alive period of accepted connections, 0 for default of Go, negative to disable
+	SockMode  os.FileMode   // permission of unix socket file, umask decides if it is 0
 }
 
 func (that *Address) String() (s string) {
@@ -15,7 +27,7 @@ func (that *Address) String() (s string) {
 		that.Network = "tcp"
 	}
 	switch that.Network {
-	case "unix", "unixpacket":
+	case "unix", "unixpacket", "unixgram":
 		s = fmt.Sprintf("%s@%s", that.Network, that.Sock)
 	default:
 		if that.Host == "" {
@@ -31,17 +43,34 @@ func (that *Address) Addr() (s string) {
 		that.Network = "tcp"
 	}
 	switch that.Network {
-	case "unix", "unixpacket":
+	case "unix", "unixpacket", "unixgram":
 		s = that.Sock
 	default:
-		s = fmt.Sprintf("%s:%d", that.Host, that.Port)
+		s = net.JoinHostPort(that.Host, strconv.Itoa(that.Port))
 	}
 	return
 }
 
+// innerAddr is the IAddress of servers started by Grace itself
+type innerAddr struct {
+	addr *Address
+}
+
+func (that *innerAddr) GetAddr() *Address { return that.addr }
+func (that *innerAddr) SetGrace(g *Grace) {}
+
+// IsPacket return true for packet oriented networks
+func (that *Address) IsPacket() bool {
+	switch that.Network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
 func (that *Address) Check() error {
 	switch that.Network {
-	case "unix", "unixpacket":
+	case "unix", "unixpacket", "unixgram":
 		if that.Sock == "" {
 			return fmt.Errorf("invalid address!")
 		}
@@ -52,3 +81,16 @@ func (that *Address) Check() error {
 	}
 	return nil
 }
+
+// control set socket options of Address before binding
+func (that *Address) control(network, address string, c syscall.RawConn) (err error) {
+	if !that.ReusePort {
+		return nil
+	}
+	if e := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); e != nil {
+		return e
+	}
+	return
+}
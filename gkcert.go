@@ -0,0 +1,267 @@
+package gkgrace
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/os/gfsnotify"
+)
+
+// delay of reloading after the last change of certificate files
+const CertReloadDelay = 500 * time.Millisecond
+
+type certPair struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+// CertManager serves certificates through tls.Config.GetCertificate and reloads them without restart,
+// certificates are chosen by SNI, the first one is the default
+type CertManager struct {
+	Logger    Logger
+	mu        sync.RWMutex
+	pairs     []*certPair
+	names     map[string]*tls.Certificate // server names, wildcards as "*.example.com"
+	callbacks []int                       // ids of gfsnotify callbacks
+	timer     *time.Timer
+}
+
+func NewCertManager() *CertManager {
+	return &CertManager{
+		Logger: DefaultLogger,
+		names:  make(map[string]*tls.Certificate),
+	}
+}
+
+// AddCertificate add a certificate in memory, it is not reloaded
+func (that *CertManager) AddCertificate(cert tls.Certificate) error {
+	if len(cert.Certificate) == 0 {
+		return fmt.Errorf("tls: empty certificate")
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("tls: cannot parse certificate: %s", err.Error())
+		}
+		cert.Leaf = leaf
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.pairs = append(that.pairs, &certPair{cert: &cert})
+	that.index()
+	return nil
+}
+
+// Add load a certificate, it is ignored if added before
+func (that *CertManager) Add(certFile, keyFile string) error {
+	certFile, _ = filepath.Abs(certFile)
+	keyFile, _ = filepath.Abs(keyFile)
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	for _, p := range that.pairs {
+		if p.certFile == certFile && p.keyFile == keyFile {
+			return nil
+		}
+	}
+	p := &certPair{certFile: certFile, keyFile: keyFile}
+	if err := p.load(); err != nil {
+		return err
+	}
+	that.pairs = append(that.pairs, p)
+	that.index()
+	return nil
+}
+
+func (that *certPair) load() error {
+	if that.certFile == "" {
+		// in memory
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(that.certFile, that.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: cannot load %s: %s", that.certFile, err.Error())
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("tls: cannot parse %s: %s", that.certFile, err.Error())
+		}
+	}
+	that.cert = &cert
+	return nil
+}
+
+// index rebuild server names, the first certificate wins for duplicated names
+func (that *CertManager) index() {
+	names := make(map[string]*tls.Certificate)
+	for _, p := range that.pairs {
+		leaf := p.cert.Leaf
+		for _, name := range append([]string{leaf.Subject.CommonName}, leaf.DNSNames...) {
+			name = strings.ToLower(name)
+			if _, found := names[name]; name != "" && !found {
+				names[name] = p.cert
+			}
+		}
+	}
+	that.names = names
+}
+
+// Reload load every certificate from disk again, certificates failed to load are kept
+func (that *CertManager) Reload() error {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	var errs []string
+	for _, p := range that.pairs {
+		if err := p.load(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	that.index()
+	if len(errs) > 0 {
+		that.Logger.Error("certificates are not reloaded", "err", strings.Join(errs, "; "))
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	that.Logger.Info("certificates reloaded", "count", len(that.pairs))
+	return nil
+}
+
+// GetCertificate choose a certificate by SNI, exact names first, then wildcards, then the default
+func (that *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	if len(that.pairs) == 0 {
+		return nil, fmt.Errorf("tls: no certificate")
+	}
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, found := that.names[name]; found {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, found := that.names["*"+name[i:]]; found {
+			return cert, nil
+		}
+	}
+	return that.pairs[0].cert, nil
+}
+
+// TLSConfig return a tls.Config serving certificates of the manager
+func (that *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: that.GetCertificate,
+	}
+}
+
+// Watch reload certificates when their files change
+func (that *CertManager) Watch() error {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.unwatch()
+	for _, p := range that.pairs {
+		if p.certFile == "" {
+			continue
+		}
+		for _, f := range []string{p.certFile, p.keyFile} {
+			c, err := gfsnotify.Add(f, func(event *gfsnotify.Event) {
+				that.reloadLater()
+			}, false)
+			if err != nil {
+				that.unwatch()
+				return err
+			}
+			that.callbacks = append(that.callbacks, c.Id)
+		}
+	}
+	return nil
+}
+
+// reloadLater reload after files stop changing, cert and key are often written one by one
+func (that *CertManager) reloadLater() {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.timer != nil {
+		that.timer.Stop()
+	}
+	that.timer = time.AfterFunc(CertReloadDelay, func() {
+		that.Reload()
+	})
+}
+
+// Close stop watching files
+func (that *CertManager) Close() {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.unwatch()
+	if that.timer != nil {
+		that.timer.Stop()
+	}
+}
+
+func (that *CertManager) unwatch() {
+	for _, id := range that.callbacks {
+		gfsnotify.RemoveCallback(id)
+	}
+	that.callbacks = nil
+}
+
+// certManagerOf return a CertManager of options, managers of the same files are shared and watched once
+func (that *Grace) certManagerOf(opts *TLSOptions) (*CertManager, error) {
+	key := strings.Join(opts.CertFiles, "\n")
+	that.certsMu.Lock()
+	defer that.certsMu.Unlock()
+	if len(opts.Certificates) == 0 {
+		if m, found := that.certsByFiles[key]; found {
+			return m, nil
+		}
+	}
+	m := NewCertManager()
+	m.Logger = that.Logger
+	for i := 0; i+1 < len(opts.CertFiles); i += 2 {
+		if err := m.Add(opts.CertFiles[i], opts.CertFiles[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	for _, cert := range opts.Certificates {
+		if err := m.AddCertificate(cert); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.CertFiles) > 0 {
+		if err := m.Watch(); err != nil {
+			that.Logger.Warn("certificate files are not watched", "err", err)
+		}
+	}
+	if len(opts.Certificates) == 0 {
+		if that.certsByFiles == nil {
+			that.certsByFiles = make(map[string]*CertManager)
+		}
+		that.certsByFiles[key] = m
+	}
+	that.certManagers.Append(m)
+	return m, nil
+}
+
+// TLSConfig return a tls.Config of certs: certFile, keyFile, [certFile, keyFile]..., see NewTLSConfig
+func (that *Grace) TLSConfig(certs ...string) (*tls.Config, error) {
+	return that.NewTLSConfig(TLSFiles(certs...))
+}
+
+// ReloadCerts reload certificates of every CertManager
+func (that *Grace) ReloadCerts() error {
+	var errs []string
+	that.certManagers.Iterator(func(_ int, v interface{}) bool {
+		if err := v.(*CertManager).Reload(); err != nil {
+			errs = append(errs, err.Error())
+		}
+		return true
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
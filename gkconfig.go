@@ -0,0 +1,605 @@
+package gkgrace
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/gogf/gf/v2/encoding/gjson"
+)
+
+// GraceEnvConfigPrefix prefixes environment variables overriding config keys,
+// "listeners.0.port" is overridden by GRACE_CFG_LISTENERS_0_PORT, lists are separated by commas
+const GraceEnvConfigPrefix = "GRACE_CFG_" // not "GRACE_", which names variables passed to child processes
+
+// Config is the configuration file of Grace and its apps, in JSON, YAML or TOML
+type Config struct {
+	Mode              string            `json:"mode"`              // "single", "multi" or "supervisor", single by default
+	Workers           int               `json:"workers"`           // number of worker processes in multi-process mode
+	ReloadMode        string            `json:"reloadMode"`        // "fork" or "exec", fork by default
+	LogLevel          string            `json:"logLevel"`          // "debug", "info", "warn", "error" or "none"
+	MaxWaitTime       time.Duration     `json:"maxWaitTime"`       // like "15s"
+	LameDuck          time.Duration     `json:"lameDuck"`          // like "5s"
+	StateTimeout      time.Duration     `json:"stateTimeout"`      // like "5s"
+	UpgradeTimeout    time.Duration     `json:"upgradeTimeout"`    // like "1m"
+	TicketKeyRotation time.Duration     `json:"ticketKeyRotation"` // like "1h"
+	ConnHandoff       bool              `json:"connHandoff"`       // hand off established connections during reload
+	Signals           SignalsConfig     `json:"signals"`           // signals of actions
+	PidFile           string            `json:"pidFile"`           // written by the serving process
+	ControlSocket     string            `json:"controlSocket"`     // path of unix control socket
+	Listeners         []*ListenerConfig `json:"listeners"`         // listeners of apps
+}
+
+// SignalsConfig signals of actions, like "SIGUSR1"
+type SignalsConfig struct {
+	Reload string `json:"reload"` // reloads the process in addition to SIGUSR2
+}
+
+// ListenerConfig is a listener of an app, mapped onto Address and TLSOptions
+type ListenerConfig struct {
+	App       string        `json:"app"`       // name of the app serving it, an app may have several listeners
+	Network   string        `json:"network"`   // "tcp", "tcp4", "tcp6", "unix", "udp"..., tcp by default
+	Host      string        `json:"host"`      // host ip, "0.0.0.0" by default
+	Port      int           `json:"port"`      // port
+	Sock      string        `json:"sock"`      // unix domain socket file path
+	ReusePort bool          `json:"reusePort"` // set SO_REUSEPORT
+	KeepAlive time.Duration `json:"keepAlive"` // TCP keep-alive period, negative to disable
+	SockMode  string        `json:"sockMode"`  // permission of unix socket file in octal, like "0660"
+	TLS       *TLSConfig    `json:"tls"`       // no TLS if not set
+}
+
+// TLSConfig is TLS of a listener, mapped onto TLSOptions
+type TLSConfig struct {
+	Certs                  []string `json:"certs"`                  // certFile, keyFile, [certFile, keyFile]...
+	ClientCAs              []string `json:"clientCAs"`              // PEM files of CAs verifying client certificates
+	ClientAuth             string   `json:"clientAuth"`             // "none", "request", "require", "verify-if-given" or "require-and-verify"
+	MinVersion             string   `json:"minVersion"`             // "1.0", "1.1", "1.2" or "1.3"
+	CipherSuites           []string `json:"cipherSuites"`           // names like "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+	NextProtos             []string `json:"nextProtos"`             // ALPN, defaults of the app if empty
+	SessionTicketsDisabled bool     `json:"sessionTicketsDisabled"` // disable session resumption by tickets
+}
+
+var (
+	configModes      = []string{"", "single", "multi", "supervisor"}
+	configReloadMode = map[string]ReloadMode{"": ReloadFork, "fork": ReloadFork, "exec": ReloadExec}
+	configSignals    = map[string]syscall.Signal{"SIGHUP": syscall.SIGHUP, "SIGUSR1": syscall.SIGUSR1, "SIGUSR2": syscall.SIGUSR2}
+	configClientAuth = map[string]tls.ClientAuthType{
+		"":                   tls.NoClientCert,
+		"none":               tls.NoClientCert,
+		"request":            tls.RequestClientCert,
+		"require":            tls.RequireAnyClientCert,
+		"verify-if-given":    tls.VerifyClientCertIfGiven,
+		"require-and-verify": tls.RequireAndVerifyClientCert,
+	}
+	configTLSVersions = map[string]uint16{
+		"":    0,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// LoadConfig read config file, apply environment overrides and validate it, the type is told by extension
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if !gjson.IsValidDataType(ext) {
+		return nil, fmt.Errorf("config: file type %q of %s is not supported!", ext, path)
+	}
+	j, err := gjson.LoadContentType(ext, content)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	c := &Config{}
+	if err := decodeConfig("", j.Interface(), reflect.ValueOf(c).Elem()); err != nil {
+		return nil, err
+	}
+	if err := overrideConfig("", reflect.ValueOf(c).Elem()); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewFromConfig load config file and return a Grace configured by it, see LoadConfig and ApplyConfig
+func NewFromConfig(path string) (*Grace, error) {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.ControlSocket != "" && os.Getenv(GraceEnvControlSock) == "" {
+		// New upgrades from the process listening on it
+		os.Setenv(GraceEnvControlSock, c.ControlSocket)
+	}
+	g := New()
+	if err := g.ApplyConfig(c); err != nil {
+		return nil, err
+	}
+	g.ConfigFile = path
+	return g, nil
+}
+
+// ApplyConfig set Grace by config, keys not set keep their values, call it before Register
+func (that *Grace) ApplyConfig(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	switch c.Mode {
+	case "multi":
+		that.SetToMulti()
+	case "supervisor":
+		that.SetToSupervisor()
+	}
+	if c.Workers > 0 {
+		that.Workers = c.Workers
+	}
+	if c.ReloadMode != "" {
+		that.SetReloadMode(configReloadMode[c.ReloadMode])
+	}
+	if c.LogLevel != "" {
+		level, _ := ParseLogLevel(c.LogLevel)
+		that.SetLogLevel(level)
+	}
+	if c.MaxWaitTime > 0 {
+		that.SetMaxWait(c.MaxWaitTime)
+	}
+	if c.LameDuck > 0 {
+		that.SetLameDuck(c.LameDuck)
+	}
+	if c.StateTimeout > 0 {
+		that.StateTimeout = c.StateTimeout
+	}
+	if c.UpgradeTimeout > 0 {
+		that.UpgradeTimeout = c.UpgradeTimeout
+	}
+	if c.TicketKeyRotation > 0 {
+		that.TicketKeyRotation = c.TicketKeyRotation
+	}
+	if c.ConnHandoff {
+		that.ConnHandoff = true
+	}
+	if c.Signals.Reload != "" {
+		that.ReloadSignal = configSignals[strings.ToUpper(c.Signals.Reload)]
+	}
+	if c.PidFile != "" {
+		that.PidFile = c.PidFile
+	}
+	if c.ControlSocket != "" {
+		that.ControlSocket = c.ControlSocket
+	}
+	that.Config = c
+	return nil
+}
+
+// Validate check values of config, errors begin with the key
+func (that *Config) Validate() error {
+	found := false
+	for _, m := range configModes {
+		found = found || that.Mode == m
+	}
+	if !found {
+		return fmt.Errorf("config: mode: unknown mode %q, expect one of %s", that.Mode, strings.Join(configModes[1:], ", "))
+	}
+	if that.Workers < 0 {
+		return fmt.Errorf("config: workers: must not be negative, got %d", that.Workers)
+	}
+	if _, found := configReloadMode[that.ReloadMode]; !found {
+		return fmt.Errorf("config: reloadMode: unknown reload mode %q, expect fork or exec", that.ReloadMode)
+	}
+	if _, err := ParseLogLevel(that.LogLevel); err != nil {
+		return fmt.Errorf("config: logLevel: %s", err.Error())
+	}
+	for key, d := range map[string]time.Duration{
+		"maxWaitTime":       that.MaxWaitTime,
+		"lameDuck":          that.LameDuck,
+		"stateTimeout":      that.StateTimeout,
+		"upgradeTimeout":    that.UpgradeTimeout,
+		"ticketKeyRotation": that.TicketKeyRotation,
+	} {
+		if d < 0 {
+			return fmt.Errorf("config: %s: must not be negative, got %s", key, d)
+		}
+	}
+	if s := that.Signals.Reload; s != "" {
+		if _, found := configSignals[strings.ToUpper(s)]; !found {
+			return fmt.Errorf("config: signals.reload: signal %q is not supported, expect SIGHUP, SIGUSR1 or SIGUSR2", s)
+		}
+	}
+	addrs := make(map[string]string, len(that.Listeners))
+	for i, l := range that.Listeners {
+		key := fmt.Sprintf("listeners.%d", i)
+		if l == nil {
+			return fmt.Errorf("config: %s: empty listener", key)
+		}
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("config: %s%s", key, err.Error())
+		}
+		addr := l.Address().String()
+		if other, found := addrs[addr]; found {
+			return fmt.Errorf("config: %s: address %s is duplicated with %s", key, addr, other)
+		}
+		addrs[addr] = key
+	}
+	return nil
+}
+
+// ListenersOf return listeners of app
+func (that *Config) ListenersOf(app string) (result []*ListenerConfig) {
+	for _, l := range that.Listeners {
+		if l.App == app {
+			result = append(result, l)
+		}
+	}
+	return
+}
+
+// Validate check the listener, errors begin with the key relative to it, like ".port: ..."
+func (that *ListenerConfig) Validate() error {
+	addr := that.Address()
+	switch addr.Network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket", "udp", "udp4", "udp6", "unixgram":
+	default:
+		return fmt.Errorf(".network: Network: %s is not supported!", addr.Network)
+	}
+	if err := addr.Check(); err != nil {
+		if strings.HasPrefix(addr.Network, "unix") {
+			return fmt.Errorf(".sock: unix socket needs a file path")
+		}
+		return fmt.Errorf(".port: port is not set")
+	}
+	if that.Port < 0 || that.Port > 65535 {
+		return fmt.Errorf(".port: port %d is out of range", that.Port)
+	}
+	if that.SockMode != "" {
+		if _, err := strconv.ParseUint(that.SockMode, 8, 32); err != nil {
+			return fmt.Errorf(".sockMode: %q is not an octal file mode", that.SockMode)
+		}
+	}
+	if that.TLS == nil {
+		return nil
+	}
+	if addr.IsPacket() {
+		return fmt.Errorf(".tls: TLS is not supported on %s", addr.Network)
+	}
+	opts, err := that.TLSOptions()
+	if err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf(".tls: %s", err.Error())
+	}
+	return nil
+}
+
+// Address return Address of the listener
+func (that *ListenerConfig) Address() *Address {
+	addr := &Address{
+		Network:   that.Network,
+		Host:      that.Host,
+		Port:      that.Port,
+		Sock:      that.Sock,
+		ReusePort: that.ReusePort,
+		KeepAlive: that.KeepAlive,
+	}
+	if addr.Network == "" {
+		addr.Network = "tcp"
+	}
+	if mode, err := strconv.ParseUint(that.SockMode, 8, 32); err == nil {
+		addr.SockMode = os.FileMode(mode)
+	}
+	return addr
+}
+
+// TLSOptions return TLSOptions of the listener, nil if TLS is not set
+func (that *ListenerConfig) TLSOptions() (*TLSOptions, error) {
+	c := that.TLS
+	if c == nil {
+		return nil, nil
+	}
+	opts := &TLSOptions{
+		CertFiles:              c.Certs,
+		ClientCAFiles:          c.ClientCAs,
+		NextProtos:             c.NextProtos,
+		SessionTicketsDisabled: c.SessionTicketsDisabled,
+	}
+	var found bool
+	if opts.ClientAuth, found = configClientAuth[strings.ToLower(c.ClientAuth)]; !found {
+		return nil, fmt.Errorf(".tls.clientAuth: unknown client auth %q", c.ClientAuth)
+	}
+	if c.ClientAuth == "" && len(c.ClientCAs) > 0 {
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if opts.MinVersion, found = configTLSVersions[c.MinVersion]; !found {
+		return nil, fmt.Errorf(".tls.minVersion: unknown TLS version %q", c.MinVersion)
+	}
+	for i, name := range c.CipherSuites {
+		id, found := cipherSuiteID(name)
+		if !found {
+			return nil, fmt.Errorf(".tls.cipherSuites.%d: cipher suite %s is unknown or insecure", i, name)
+		}
+		opts.CipherSuites = append(opts.CipherSuites, id)
+	}
+	return opts, nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeConfig decode value loaded by gjson into v, errors begin with the key
+func decodeConfig(key string, in interface{}, v reflect.Value) error {
+	if in == nil {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("config: %s: %s", key, fmt.Sprintf(format, args...))
+	}
+	if v.Type() == durationType {
+		s, ok := in.(string)
+		if !ok {
+			return fail("expect a duration like \"15s\", got %v", in)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fail("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeConfig(key, in, v.Elem())
+	case reflect.Struct:
+		m, ok := in.(map[string]interface{})
+		if !ok {
+			return fail("expect a table, got %v", in)
+		}
+		fields := make(map[string]int, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			fields[strings.ToLower(v.Type().Field(i).Tag.Get("json"))] = i
+		}
+		for k, value := range m {
+			i, found := fields[strings.ToLower(k)]
+			if !found {
+				return fmt.Errorf("config: %s: unknown key", joinKey(key, k))
+			}
+			if err := decodeConfig(joinKey(key, v.Type().Field(i).Tag.Get("json")), value, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		list, ok := in.([]interface{})
+		if !ok {
+			return fail("expect a list, got %v", in)
+		}
+		v.Set(reflect.MakeSlice(v.Type(), len(list), len(list)))
+		for i, value := range list {
+			if err := decodeConfig(joinKey(key, strconv.Itoa(i)), value, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		switch in.(type) {
+		case map[string]interface{}, []interface{}:
+			return fail("expect a string, got %v", in)
+		}
+		v.SetString(fmt.Sprint(in))
+	case reflect.Bool:
+		switch b := in.(type) {
+		case bool:
+			v.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return fail("expect true or false, got %q", b)
+			}
+			v.SetBool(parsed)
+		default:
+			return fail("expect true or false, got %v", in)
+		}
+	case reflect.Int:
+		var s string
+		switch n := in.(type) {
+		case string:
+			s = n
+		case json.Number:
+			s = n.String()
+		case float64:
+			s = strconv.FormatFloat(n, 'f', -1, 64)
+		case int, int64, uint64:
+			s = fmt.Sprint(n)
+		default:
+			return fail("expect an integer, got %v", in)
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fail("expect an integer, got %q", s)
+		}
+		v.SetInt(int64(n))
+	}
+	return nil
+}
+
+// overrideConfig set keys of v by environment variables
+func overrideConfig(key string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil // no new listeners or TLS from environment
+		}
+		return overrideConfig(key, v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if err := overrideConfig(joinKey(key, v.Type().Field(i).Tag.Get("json")), v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			for i := 0; i < v.Len(); i++ {
+				if err := overrideConfig(joinKey(key, strconv.Itoa(i)), v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	value, found := os.LookupEnv(configEnvName(key))
+	if !found {
+		return nil
+	}
+	var in interface{} = value
+	if v.Kind() == reflect.Slice {
+		var list []interface{}
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		in = list
+	}
+	if err := decodeConfig(key, in, v); err != nil {
+		return fmt.Errorf("%s (from %s)", err.Error(), configEnvName(key))
+	}
+	return nil
+}
+
+func joinKey(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
+
+// configEnvName return environment variable of key, "listeners.0.reusePort" to GRACE_CFG_LISTENERS_0_REUSE_PORT
+func configEnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(GraceEnvConfigPrefix)
+	for i, r := range key {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(rune(key[i-1])):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// OnConfigReload add a hook called after config is reloaded in place, like applying Workers in multi-process mode
+func (that *Grace) OnConfigReload(fn func(old, c *Config)) {
+	that.configHooks.Append(fn)
+}
+
+// ReloadConfig re-read ConfigFile and apply keys that can change in place, like maxWaitTime, logLevel, lameDuck and certs,
+// a process reload is started if others changed, like listeners; keys removed from the file are reset to defaults
+func (that *Grace) ReloadConfig() error {
+	key, err := that.reloadConfig()
+	if err != nil || key == "" {
+		return err
+	}
+	return that.reloadForConfig(key)
+}
+
+// reloadForConfig start a process reload, the next process loads config itself
+func (that *Grace) reloadForConfig(key string) error {
+	that.Logger.Info("config needs a process reload", "key", key)
+	return that.reload()
+}
+
+// reloadConfig apply config in place, it returns the changed key needing a process reload
+func (that *Grace) reloadConfig() (key string, err error) {
+	that.configMu.Lock()
+	defer that.configMu.Unlock()
+	if that.ConfigFile == "" {
+		return "", fmt.Errorf("config: no config file is loaded")
+	}
+	c, err := LoadConfig(that.ConfigFile)
+	if err != nil {
+		that.Logger.Error("config reload failed, keep running with old config", "file", that.ConfigFile, "err", err)
+		return "", err
+	}
+	old := that.Config
+	if old == nil {
+		old = &Config{}
+	}
+	if key = old.restartKey(c); key != "" {
+		return key, nil
+	}
+	that.applyLiveConfig(c)
+	if err := that.ReloadCerts(); err != nil {
+		that.Logger.Error("certs reload failed", "err", err)
+	}
+	that.configHooks.Iterator(func(_ int, v interface{}) bool {
+		v.(func(old, c *Config))(old, c)
+		return true
+	})
+	that.Logger.Info("config reloaded", "file", that.ConfigFile)
+	return "", nil
+}
+
+// applyLiveConfig apply keys which can change in place, keys not set in c are reset to defaults
+func (that *Grace) applyLiveConfig(c *Config) {
+	orDefault := func(d, def time.Duration) time.Duration {
+		if d > 0 {
+			return d
+		}
+		return def
+	}
+	level, _ := ParseLogLevel(c.LogLevel)
+	that.SetLogLevel(level)
+	that.Workers = c.Workers // nothing reads it in place, apply it in an OnConfigReload hook
+	that.SetReloadMode(configReloadMode[c.ReloadMode])
+	that.SetMaxWait(orDefault(c.MaxWaitTime, DefualtMaxWaitTime))
+	that.SetLameDuck(c.LameDuck)
+	that.StateTimeout = orDefault(c.StateTimeout, DefaultStateTimeout)
+	that.UpgradeTimeout = orDefault(c.UpgradeTimeout, DefaultUpgradeTimeout)
+	that.TicketKeyRotation = orDefault(c.TicketKeyRotation, DefaultTicketKeyRotation)
+	that.ConnHandoff = c.ConnHandoff
+	that.Config = c
+}
+
+// restartKey return the first key changed by c which cannot change in place
+func (that *Config) restartKey(c *Config) string {
+	switch {
+	case that.Mode != c.Mode:
+		return "mode"
+	case that.ControlSocket != c.ControlSocket:
+		return "controlSocket"
+	case that.PidFile != c.PidFile:
+		return "pidFile"
+	case that.Signals != c.Signals:
+		return "signals"
+	case !reflect.DeepEqual(that.Listeners, c.Listeners):
+		return "listeners"
+	}
+	return ""
+}
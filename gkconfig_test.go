@@ -0,0 +1,189 @@
+package gkgrace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"json", "grace.json", `{"mode": "multi", "workers": 2, "maxWaitTime": "15s", "connHandoff": true,
+			"listeners": [{"app": "api", "port": 8080, "reusePort": true}, {"app": "api", "network": "unix", "sock": "/tmp/api.sock"}]}`},
+		{"yaml", "grace.yaml", `
+mode: multi
+workers: 2
+maxWaitTime: 15s
+connHandoff: true
+listeners:
+  - app: api
+    port: 8080
+    reusePort: true
+  - app: api
+    network: unix
+    sock: /tmp/api.sock
+`},
+		{"toml", "grace.toml", `
+mode = "multi"
+workers = 2
+maxWaitTime = "15s"
+connHandoff = true
+[[listeners]]
+app = "api"
+port = 8080
+reusePort = true
+[[listeners]]
+app = "api"
+network = "unix"
+sock = "/tmp/api.sock"
+`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf, err := LoadConfig(writeConfig(t, c.file, c.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.Mode != "multi" || conf.Workers != 2 || conf.MaxWaitTime != 15*time.Second || !conf.ConnHandoff {
+				t.Fatalf("config = %+v", conf)
+			}
+			if len(conf.Listeners) != 2 || conf.Listeners[0].Port != 8080 || !conf.Listeners[0].ReusePort ||
+				conf.Listeners[1].Sock != "/tmp/api.sock" {
+				t.Fatalf("listeners = %+v, %+v", conf.Listeners[0], conf.Listeners[1])
+			}
+			if n := len(conf.ListenersOf("api")); n != 2 {
+				t.Fatalf("ListenersOf(api) has %d listeners, want 2", n)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown key", `{"mod": "single"}`, "config: mod: unknown key"},
+		{"duration", `{"lameDuck": 5}`, "config: lameDuck: expect a duration"},
+		{"bad duration", `{"lameDuck": "5 seconds"}`, "config: lameDuck: invalid duration"},
+		{"integer", `{"workers": "two"}`, "config: workers: expect an integer"},
+		{"bool", `{"connHandoff": "yes"}`, "config: connHandoff: expect true or false"},
+		{"list", `{"listeners": {"port": 80}}`, "config: listeners: expect a list"},
+		{"nested key", `{"listeners": [{"port": 80, "tls": {"cert": "a.crt"}}]}`, "config: listeners.0.tls.cert: unknown key"},
+		{"validate", `{"mode": "cluster"}`, "config: mode: unknown mode"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, "grace.json", c.content))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("LoadConfig() = %v, want error containing %q", err, c.err)
+			}
+		})
+	}
+	if _, err := LoadConfig(writeConfig(t, "grace.ini2", "")); err == nil {
+		t.Fatal("LoadConfig() accepted an unknown file type")
+	}
+}
+
+func TestConfigEnvName(t *testing.T) {
+	cases := []struct {
+		key  string
+		name string
+	}{
+		{"mode", "GRACE_CFG_MODE"},
+		{"maxWaitTime", "GRACE_CFG_MAX_WAIT_TIME"},
+		{"signals.reload", "GRACE_CFG_SIGNALS_RELOAD"},
+		{"listeners.0.reusePort", "GRACE_CFG_LISTENERS_0_REUSE_PORT"},
+		{"listeners.1.tls.clientCAs", "GRACE_CFG_LISTENERS_1_TLS_CLIENT_CAS"},
+	}
+	for _, c := range cases {
+		if got := configEnvName(c.key); got != c.name {
+			t.Errorf("configEnvName(%q) = %q, want %q", c.key, got, c.name)
+		}
+	}
+}
+
+func TestConfigEnvOverride(t *testing.T) {
+	path := writeConfig(t, "grace.json", `{"workers": 2, "lameDuck": "1s",
+		"listeners": [{"app": "api", "port": 8080, "tls": {"certs": ["a.crt", "a.key"]}}]}`)
+	t.Setenv("GRACE_CFG_WORKERS", "4")
+	t.Setenv("GRACE_CFG_LAME_DUCK", "3s")
+	t.Setenv("GRACE_CFG_LOG_LEVEL", "debug")
+	t.Setenv("GRACE_CFG_LISTENERS_0_PORT", "9090")
+	t.Setenv("GRACE_CFG_LISTENERS_0_TLS_NEXT_PROTOS", "h2, http/1.1")
+	t.Setenv("GRACE_CFG_LISTENERS_1_PORT", "9091") // no new listeners from environment
+	// variables passed to child processes are not config keys
+	t.Setenv(GraceEnvLogLevel, "error")
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Workers != 4 || conf.LameDuck != 3*time.Second || conf.LogLevel != "debug" {
+		t.Fatalf("config = %+v", conf)
+	}
+	if len(conf.Listeners) != 1 || conf.Listeners[0].Port != 9090 {
+		t.Fatalf("listeners = %+v", conf.Listeners)
+	}
+	if protos := conf.Listeners[0].TLS.NextProtos; len(protos) != 2 || protos[0] != "h2" || protos[1] != "http/1.1" {
+		t.Fatalf("nextProtos = %q", protos)
+	}
+
+	t.Setenv("GRACE_CFG_WORKERS", "four")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "(from GRACE_CFG_WORKERS)") {
+		t.Fatalf("LoadConfig() = %v, want error naming GRACE_CFG_WORKERS", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		conf Config
+		err  string // empty if valid
+	}{
+		{"empty", Config{}, ""},
+		{"full", Config{Mode: "supervisor", ReloadMode: "exec", LogLevel: "warn", Signals: SignalsConfig{Reload: "sighup"},
+			Listeners: []*ListenerConfig{{Port: 80}, {Network: "unix", Sock: "/tmp/a.sock", SockMode: "0660"}}}, ""},
+		{"mode", Config{Mode: "cluster"}, "config: mode:"},
+		{"workers", Config{Workers: -1}, "config: workers:"},
+		{"reload mode", Config{ReloadMode: "spawn"}, "config: reloadMode:"},
+		{"log level", Config{LogLevel: "loud"}, "config: logLevel:"},
+		{"duration", Config{MaxWaitTime: -time.Second}, "config: maxWaitTime:"},
+		{"signal", Config{Signals: SignalsConfig{Reload: "SIGKILL"}}, "config: signals.reload:"},
+		{"nil listener", Config{Listeners: []*ListenerConfig{nil}}, "config: listeners.0: empty listener"},
+		{"network", Config{Listeners: []*ListenerConfig{{Network: "sctp", Port: 80}}}, "config: listeners.0.network:"},
+		{"port", Config{Listeners: []*ListenerConfig{{Port: 80}, {}}}, "config: listeners.1.port:"},
+		{"port range", Config{Listeners: []*ListenerConfig{{Port: 70000}}}, "config: listeners.0.port:"},
+		{"sock", Config{Listeners: []*ListenerConfig{{Network: "unix"}}}, "config: listeners.0.sock:"},
+		{"sock mode", Config{Listeners: []*ListenerConfig{{Network: "unix", Sock: "/tmp/a.sock", SockMode: "rw"}}}, "config: listeners.0.sockMode:"},
+		{"duplicated", Config{Listeners: []*ListenerConfig{{Port: 80}, {Host: "0.0.0.0", Port: 80}}}, "is duplicated with listeners.0"},
+		{"tls on udp", Config{Listeners: []*ListenerConfig{{Network: "udp", Port: 53, TLS: &TLSConfig{}}}}, "config: listeners.0.tls:"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.conf.Validate()
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+				t.Fatalf("Validate() = %v, want error containing %q", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package gkgrace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// name of the inherited socket carrying established connections
+const ConnsFileName = "gkgrace.conns"
+
+// maximum size of metadata sent with a connection
+const MaxConnMetaSize = 32 << 10
+
+// ConnSender sends c to child process, c is closed in current process once sent
+type ConnSender func(listener string, c net.Conn, meta []byte) error
+
+// ConnExporter selects connections to hand off, it is called when child process has taken over
+type ConnExporter func(send ConnSender) error
+
+// ConnAdopter adopts a connection sent by parent process
+type ConnAdopter func(c net.Conn, meta []byte)
+
+type connMessage struct {
+	Listener string `json:"listener"`
+	Meta     []byte `json:"meta,omitempty"`
+}
+
+// EnableConnHandoff enable handing off established connections during reload
+func (that *Grace) EnableConnHandoff() {
+	that.ConnHandoff = true
+}
+
+// OnConnHandoff register an exporter called in parent process after handoff
+func (that *Grace) OnConnHandoff(fn ConnExporter) {
+	that.connExporters = append(that.connExporters, fn)
+}
+
+// OnConnAdopt register an adopter for connections accepted on listener in parent process
+func (that *Grace) OnConnAdopt(listener string, fn ConnAdopter) {
+	that.connAdopters.Set(listener, fn)
+}
+
+// prepareConnHandoff pass one end of a unix socketpair to child process
+func (that *Grace) prepareConnHandoff(h *handoff) error {
+	if !that.ConnHandoff || that.IsSupervisor {
+		return nil
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	local := os.NewFile(uintptr(fds[0]), ConnsFileName)
+	remote := os.NewFile(uintptr(fds[1]), ConnsFileName)
+	h.addFile(ConnsFileName, remote)
+	h.started = append(h.started, func() {
+		defer local.Close()
+		c, err := net.FileConn(local)
+		if err != nil {
+			that.Logger.Error("connection handoff disabled", "phase", GraceReloading, "err", err)
+			return
+		}
+		that.connSock = c.(*net.UnixConn)
+	})
+	h.aborted = append(h.aborted, func() {
+		local.Close()
+	})
+	return nil
+}
+
+// exportConns call exporters and send selected connections to child process
+func (that *Grace) exportConns() {
+	if that.connSock == nil {
+		return
+	}
+	defer that.connSock.Close()
+	sent := 0
+	send := func(listener string, c net.Conn, meta []byte) error {
+		if len(meta) > MaxConnMetaSize {
+			return fmt.Errorf("conns: metadata exceeds %d bytes", MaxConnMetaSize)
+		}
+		f, err := connFile(c)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		b, _ := json.Marshal(connMessage{Listener: listener, Meta: meta})
+		if _, _, err := that.connSock.WriteMsgUnix(b, syscall.UnixRights(int(f.Fd())), nil); err != nil {
+			return err
+		}
+		sent++
+		return c.Close()
+	}
+	for _, fn := range that.connExporters {
+		if err := fn(send); err != nil {
+			that.Logger.Error("connection exporter failed", "phase", GraceHandedOff, "err", err)
+		}
+	}
+	that.Logger.Info("connections handed off", "phase", GraceHandedOff, "count", sent)
+}
+
+// connFile return a duplicate file of the socket under c
+func connFile(c net.Conn) (*os.File, error) {
+	for {
+		switch v := c.(type) {
+		case interface{ File() (*os.File, error) }:
+			return v.File()
+		case interface{ Unwrap() net.Conn }:
+			c = v.Unwrap()
+		default:
+			return nil, fmt.Errorf("conns: %T cannot be handed off", c)
+		}
+	}
+}
+
+// AdoptConns receive connections from parent process until it closes the socket
+func (that *Grace) AdoptConns() {
+	f, found := that.Manifest.Lookup(ConnsFileName)
+	if !found || f.Kind != KindFile {
+		return
+	}
+	file := os.NewFile(uintptr(f.Fd), ConnsFileName)
+	c, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		that.Logger.Error("cannot adopt connections", "err", err)
+		return
+	}
+	sock := c.(*net.UnixConn)
+	defer sock.Close()
+	b := make([]byte, MaxConnMetaSize*2)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	for {
+		n, oobn, _, _, err := sock.ReadMsgUnix(b, oob)
+		if err != nil || n == 0 {
+			return
+		}
+		msg := connMessage{}
+		if err := json.Unmarshal(b[:n], &msg); err != nil {
+			that.Logger.Error("invalid connection message", "err", err)
+			continue
+		}
+		conn, err := parseConn(oob[:oobn], msg.Listener)
+		if err != nil {
+			that.Logger.Error("cannot adopt connection", "listener", msg.Listener, "err", err)
+			continue
+		}
+		if fn, ok := that.connAdopters.Get(msg.Listener).(ConnAdopter); ok {
+			fn(conn, msg.Meta)
+		} else {
+			that.Logger.Warn("no adopter for connection, closed", "listener", msg.Listener)
+			conn.Close()
+		}
+	}
+}
+
+func parseConn(oob []byte, name string) (net.Conn, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("conns: invalid control message")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("conns: invalid unix rights")
+	}
+	f := os.NewFile(uintptr(fds[0]), name)
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// adoptingListener returns connections adopted from parent process before accepting new ones
+type adoptingListener struct {
+	net.Listener
+	conns   chan net.Conn
+	results chan acceptResult
+	closed  chan struct{}
+	once    sync.Once
+	close   sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newAdoptingListener(l net.Listener) *adoptingListener {
+	return &adoptingListener{
+		Listener: l,
+		conns:    make(chan net.Conn, 64),
+		results:  make(chan acceptResult),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (that *adoptingListener) adopt(c net.Conn, _ []byte) {
+	select {
+	case that.conns <- c:
+	case <-that.closed:
+		c.Close()
+	}
+}
+
+func (that *adoptingListener) pump() {
+	for {
+		c, err := that.Listener.Accept()
+		select {
+		case that.results <- acceptResult{c, err}:
+		case <-that.closed:
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (that *adoptingListener) Accept() (net.Conn, error) {
+	that.once.Do(func() { go that.pump() })
+	select {
+	case c := <-that.conns:
+		return c, nil
+	case r := <-that.results:
+		return r.conn, r.err
+	case <-that.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (that *adoptingListener) Close() error {
+	that.close.Do(func() { close(that.closed) })
+	return that.Listener.Close()
+}
+
+// Unwrap return the original listener
+func (that *adoptingListener) Unwrap() net.Listener {
+	return that.Listener
+}
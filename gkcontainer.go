@@ -1,14 +1,15 @@
 package gkgrace
 
 import (
+	"fmt"
 	"net"
+	"os"
 
 	"github.com/gogf/gf/container/gmap"
 	"github.com/gogf/gf/v2/container/garray"
-	"github.com/moqsien/processes/logger"
 )
 
-// Container is listener container
+// Container is listener container, packet conns and plain files are also supported
 type Container struct {
 	Names *garray.SortedStrArray
 	Data  *gmap.StrAnyMap
@@ -21,7 +22,7 @@ func NewContainer() *Container {
 }
 
 // Add add listener to container
-func (that *Container) Add(name string, l any) {
+func (that *Container) Add(name string, l any) error {
 	if that.Data == nil {
 		that.Data = gmap.NewStrAnyMap(true)
 	}
@@ -29,12 +30,13 @@ func (that *Container) Add(name string, l any) {
 		panic("Listener duplicated!")
 	}
 	switch l.(type) {
-	case *net.TCPListener, *net.UnixListener:
+	case *net.TCPListener, *net.UnixListener, *net.UDPConn, *net.UnixConn, *os.File:
 		that.AddNull(name)
 		that.Data.Set(name, l)
 	default:
-		logger.Errorf("Listener : %s is not supported!", name)
+		return fmt.Errorf("Listener : %s is not supported!", name)
 	}
+	return nil
 }
 
 // Add add null listener to container
@@ -44,6 +46,50 @@ func (that *Container) AddNull(name string) {
 	}
 }
 
+// File return a duplicate of the underlying file and its description
+func (that *Container) File(name string) (f *os.File, desc HandoffFile, err error) {
+	var l any
+	if that.Data != nil {
+		l = that.Data.Get(name)
+	}
+	desc.Name = name
+	switch v := l.(type) {
+	case *net.TCPListener:
+		desc.Kind, desc.Network, desc.Address = KindListener, v.Addr().Network(), v.Addr().String()
+		f, err = v.File()
+	case *net.UnixListener:
+		desc.Kind, desc.Network, desc.Address = KindListener, v.Addr().Network(), v.Addr().String()
+		f, err = v.File()
+	case *net.UDPConn:
+		desc.Kind, desc.Network, desc.Address = KindPacketConn, v.LocalAddr().Network(), v.LocalAddr().String()
+		f, err = v.File()
+	case *net.UnixConn:
+		desc.Kind, desc.Network, desc.Address = KindPacketConn, v.LocalAddr().Network(), v.LocalAddr().String()
+		f, err = v.File()
+	case *os.File:
+		desc.Kind = KindFile
+		f = v
+	case nil:
+		err = fmt.Errorf("Listener : %s is not found!", name)
+	default:
+		err = fmt.Errorf("Listener : %s is not supported!", name)
+	}
+	return
+}
+
+// keepSocketFiles stop unix listeners removing their socket files when closed, the next generation serves them
+func (that *Container) keepSocketFiles() {
+	if that.Data == nil {
+		return
+	}
+	that.Data.Iterator(func(_ string, v interface{}) bool {
+		if l, ok := v.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+		return true
+	})
+}
+
 // SearchIndex find the index of a listener, return -1 if not found
 func (that *Container) SearchIndex(name string) int {
 	return that.Names.Search(name)
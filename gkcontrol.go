@@ -0,0 +1,351 @@
+package gkgrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// name of the control socket in Container and handoff manifest
+const ControlFileName = "gkgrace.control"
+
+// maximum time an upgrading process may take before it asks the old one to drain
+const DefaultUpgradeTimeout = time.Minute
+
+// permission of ControlSocket, peers of other users than root and the owner are rejected too
+const ControlSocketMode os.FileMode = 0600
+
+// ControlHandler handles a command received from the control socket, returned error is replied as "ERR ..."
+type ControlHandler func(c *ControlConn, args []string) error
+
+// ControlConn is a connection to the control socket, commands and replies are single lines
+type ControlConn struct {
+	*net.UnixConn
+	r *bufio.Reader
+}
+
+// ReadLine read a line without the trailing newline
+func (that *ControlConn) ReadLine() (string, error) {
+	line, err := that.r.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+// OK reply success with an optional message
+func (that *ControlConn) OK(msg string) error {
+	return that.reply("OK", msg, nil)
+}
+
+// Error reply failure
+func (that *ControlConn) Error(err error) error {
+	return that.reply("ERR", err.Error(), nil)
+}
+
+func (that *ControlConn) reply(status, msg string, oob []byte) error {
+	line := status
+	if msg != "" {
+		line += " " + strings.ReplaceAll(msg, "\n", " ")
+	}
+	_, _, err := that.WriteMsgUnix([]byte(line+"\n"), oob, nil)
+	return err
+}
+
+// HandleControl register a handler for command, commands are case-insensitive
+func (that *Grace) HandleControl(cmd string, fn ControlHandler) {
+	that.controls.Set(strings.ToUpper(cmd), fn)
+}
+
+func (that *Grace) initControl() {
+	that.HandleControl("PING", func(c *ControlConn, _ []string) error {
+		return c.OK("PONG")
+	})
+	that.HandleControl("STATUS", func(c *ControlConn, _ []string) error {
+		return c.OK(fmt.Sprintf("%s pid=%d generation=%d", that.Status.Get(), os.Getpid(), that.Generation))
+	})
+	that.HandleControl("UPGRADE", that.handleUpgrade)
+	that.HandleControl("RELOAD-CERTS", func(c *ControlConn, _ []string) error {
+		if err := that.ReloadCerts(); err != nil {
+			return err
+		}
+		return c.OK("")
+	})
+	that.HandleControl("RELOAD-CONFIG", func(c *ControlConn, _ []string) error {
+		key, err := that.reloadConfig()
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return c.OK("")
+		}
+		if err := that.reloadForConfig(key); err != nil {
+			return err
+		}
+		return c.OK("process reload: " + key + " changed")
+	})
+	that.OnTransition(GraceHandedOff, func(Transition) {
+		// the next generation serves the control socket now
+		if l := that.controlListener; l != nil && !that.IsSupervisor {
+			l.SetUnlinkOnClose(false)
+			l.Close()
+		}
+	})
+}
+
+// ServeControl listen on ControlSocket, the socket is inherited from previous generation if possible
+func (that *Grace) ServeControl() error {
+	if that.ControlSocket == "" || that.controlListener != nil || that.Supervised {
+		return nil // workers are controlled through supervisor
+	}
+	var (
+		l   net.Listener
+		err error
+	)
+	if f, found := that.Manifest.Lookup(ControlFileName); found && f.Kind == KindListener {
+		file := os.NewFile(uintptr(f.Fd), ControlFileName)
+		l, err = net.FileListener(file)
+		file.Close()
+	} else {
+		if c, err := net.Dial("unix", that.ControlSocket); err == nil {
+			c.Close()
+			return fmt.Errorf("control: %s is in use", that.ControlSocket)
+		}
+		os.Remove(that.ControlSocket) // stale socket
+		if l, err = net.Listen("unix", that.ControlSocket); err == nil {
+			if err = os.Chmod(that.ControlSocket, ControlSocketMode); err != nil {
+				l.Close()
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("control: %s", err.Error())
+	}
+	ul, ok := l.(*net.UnixListener)
+	if !ok {
+		l.Close()
+		return fmt.Errorf("control: %s is not a unix socket", that.ControlSocket)
+	}
+	if err := that.Listeners.Add(ControlFileName, ul); err != nil {
+		ul.Close()
+		return err
+	}
+	that.controlListener = ul
+	that.Logger.Info("control socket is ready", "socket", that.ControlSocket)
+	go func() {
+		for {
+			c, err := ul.AcceptUnix()
+			if err != nil {
+				return
+			}
+			if err := checkPeer(c); err != nil {
+				that.Logger.Warn("control connection rejected", "err", err)
+				c.Close()
+				continue
+			}
+			go that.serveControlConn(&ControlConn{UnixConn: c, r: bufio.NewReader(c)})
+		}
+	}()
+	return nil
+}
+
+// checkPeer allow root and the user of the process only, the socket may be created before chmod
+func checkPeer(c *net.UnixConn) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	if err := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cred.Uid != 0 && int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("control: uid %d of pid %d is not allowed", cred.Uid, cred.Pid)
+	}
+	return nil
+}
+
+func (that *Grace) serveControlConn(c *ControlConn) {
+	defer c.Close()
+	for {
+		line, err := c.ReadLine()
+		if line == "" {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		args := strings.Fields(line)
+		fn, ok := that.controls.Get(strings.ToUpper(args[0])).(ControlHandler)
+		if !ok {
+			c.Error(fmt.Errorf("unknown command %q", args[0]))
+			continue
+		}
+		that.Logger.Debug("control command received", "command", args[0])
+		if err := fn(c, args[1:]); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// handleUpgrade pass inherited files to an unrelated process and drain once it asks to
+func (that *Grace) handleUpgrade(c *ControlConn, _ []string) error {
+	if that.IsMulti || that.IsSupervisor {
+		return fmt.Errorf("upgrade is not supported in multi-process mode and supervisor mode")
+	}
+	if err := that.Status.Transit(GraceReloading, "control: upgrade"); err != nil {
+		return err
+	}
+	h := &handoff{}
+	h.files, h.manifest = that.prepareHandoff(that.Generation + 1)
+	defer that.closeFiles(h.files)
+	err := that.prepareStateExport(h)
+	if err == nil {
+		err = that.prepareConnHandoff(h)
+	}
+	var content []byte
+	if err == nil {
+		content, err = h.manifest.Encode()
+	}
+	if err == nil {
+		fds := make([]int, 0, len(h.files))
+		for _, f := range h.files {
+			fds = append(fds, int(f.Fd()))
+		}
+		err = c.reply("OK", string(content), syscall.UnixRights(fds...))
+	}
+	if err != nil {
+		h.done(false)
+		that.setStatus(GraceRunning, "upgrade failed: "+err.Error())
+		return err
+	}
+	h.done(true)
+	that.Logger.Info("files passed to upgrading process", "phase", GraceReloading, "files", len(h.files))
+
+	c.SetReadDeadline(time.Now().Add(that.UpgradeTimeout))
+	line, err := c.ReadLine()
+	if !strings.EqualFold(line, "DRAIN") {
+		if that.connSock != nil {
+			that.connSock.Close()
+			that.connSock = nil
+		}
+		if that.Status.TransitFrom(GraceReloading, GraceRunning, "upgrading process gave up") {
+			that.Logger.Error("Upgrading process gave up!", "phase", GraceReloading, "reply", line, "err", err)
+		}
+		return fmt.Errorf("upgrade aborted")
+	}
+	c.OK("")
+	go func() { that.Signal <- syscall.SIGQUIT }()
+	return nil
+}
+
+// upgrade take over files from the process serving ControlSocket, return false if nothing is taken over
+func (that *Grace) upgrade() (bool, error) {
+	conn, err := net.Dial("unix", that.ControlSocket)
+	if err != nil {
+		return false, nil // no process to upgrade from
+	}
+	c := &ControlConn{UnixConn: conn.(*net.UnixConn)}
+	c.SetDeadline(time.Now().Add(that.UpgradeTimeout))
+	if _, err := c.Write([]byte("UPGRADE\n")); err != nil {
+		c.Close()
+		return false, err
+	}
+	b := make([]byte, 1<<20)
+	oob := make([]byte, syscall.CmsgSpace(4*256))
+	n, oobn, _, _, err := c.ReadMsgUnix(b, oob)
+	if err != nil {
+		c.Close()
+		return false, err
+	}
+	var fds []int
+	if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for i := range msgs {
+			if v, err := syscall.ParseUnixRights(&msgs[i]); err == nil {
+				fds = append(fds, v...)
+			}
+		}
+	}
+	closeFds := func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		c.Close()
+	}
+	line := strings.TrimSpace(string(b[:n]))
+	if !strings.HasPrefix(line, "OK ") {
+		closeFds()
+		return false, fmt.Errorf("control: upgrade refused: %s", line)
+	}
+	m := &HandoffManifest{}
+	if err := json.Unmarshal([]byte(line[3:]), m); err != nil {
+		closeFds()
+		return false, fmt.Errorf("manifest: %s", err.Error())
+	}
+	if len(m.Files) != len(fds) {
+		closeFds()
+		return false, fmt.Errorf("manifest: %d files described, %d received", len(m.Files), len(fds))
+	}
+	for i := range m.Files {
+		m.Files[i].Fd = fds[i]
+	}
+	if err := m.Validate(m.Generation); err != nil {
+		closeFds()
+		return false, err
+	}
+	c.SetDeadline(time.Time{})
+	c.r = bufio.NewReader(c)
+	that.Manifest = m
+	that.Generation = m.Generation
+	that.IsChild = true
+	that.upgradeConn = c
+	return true, nil
+}
+
+// notifyUpgraded ask the old process to drain
+func (that *Grace) notifyUpgraded() {
+	c := that.upgradeConn
+	that.upgradeConn = nil
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(that.MaxWaitTime))
+	if _, err := c.Write([]byte("DRAIN\n")); err != nil {
+		that.Logger.Error("failed to ask old process to drain", "parent", that.Manifest.ParentPid, "err", err)
+		return
+	}
+	if line, err := c.ReadLine(); !strings.HasPrefix(line, "OK") {
+		that.Logger.Error("old process refused to drain", "parent", that.Manifest.ParentPid, "reply", line, "err", err)
+		return
+	}
+	that.Logger.Info("Gracefully upgraded, old process is draining", "parent", that.Manifest.ParentPid)
+}
+
+// ControlCommand send a command to the control socket at path and return the reply
+func ControlCommand(path string, cmd ...string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(DefaultUpgradeTimeout))
+	if _, err := conn.Write([]byte(strings.Join(cmd, " ") + "\n")); err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "OK" || strings.HasPrefix(line, "OK "):
+		return strings.TrimSpace(line[2:]), nil
+	case strings.HasPrefix(line, "ERR"):
+		return "", fmt.Errorf("control: %s", strings.TrimSpace(line[3:]))
+	case err != nil:
+		return "", err
+	}
+	return "", fmt.Errorf("control: invalid reply %q", line)
+}
@@ -0,0 +1,144 @@
+package gkgrace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReloadMode decides how a single-process reload starts the next generation
+type ReloadMode int
+
+const (
+	ReloadFork ReloadMode = iota // start a child process, requests overlap while the parent drains
+	ReloadExec                   // drain, then replace the process image in place, pid is kept
+)
+
+func (that ReloadMode) String() string {
+	if that == ReloadExec {
+		return "exec"
+	}
+	return "fork"
+}
+
+// SetReloadMode set reload mode for single-process mode
+func (that *Grace) SetReloadMode(mode ReloadMode) {
+	that.ReloadMode = mode
+}
+
+// prepareExec collect files for the next image, it is executed by exit after draining
+func (that *Grace) prepareExec() (err error) {
+	ex, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// apps are drained before exec, so a binary which cannot be executed is refused here
+	if err := checkExecutable(ex); err != nil {
+		return err
+	}
+	h := &handoff{}
+	h.files, h.manifest = that.prepareHandoff(that.Generation + 1)
+	defer func() {
+		if err != nil {
+			that.closeFiles(h.files)
+		}
+	}()
+	var state *os.File
+	if that.exporters.Size() > 0 {
+		// the image is replaced, so state is kept in memory instead of a pipe
+		fd, err := unix.MemfdCreate(StateFileName, unix.MFD_CLOEXEC)
+		if err != nil {
+			return err
+		}
+		state = os.NewFile(uintptr(fd), StateFileName)
+		h.addFile(StateFileName, state)
+	}
+	// fds are kept by exec
+	for i := range h.manifest.Files {
+		h.manifest.Files[i].Fd = int(h.files[i].Fd())
+	}
+	content, err := h.manifest.Encode()
+	if err != nil {
+		return err
+	}
+	env := that.nextEnv(h.manifest)
+	env[GraceEnvManifest] = string(content)
+	that.execReload = func() error {
+		if state != nil {
+			if err := that.exportState(state); err != nil {
+				that.Logger.Error("state export failed", "phase", GraceExiting, "err", err)
+			}
+			state.Seek(0, 0)
+		}
+		for _, f := range h.files {
+			if _, err := unix.FcntlInt(f.Fd(), unix.F_SETFD, 0); err != nil {
+				return fmt.Errorf("exec: clear close-on-exec of %s: %s", f.Name(), err.Error())
+			}
+		}
+		os.Chdir(WorkingDir)
+		that.Logger.Info("replacing process image", "phase", GraceExiting, "files", len(h.files))
+		err := syscall.Exec(ex, append([]string{ex}, os.Args[1:]...), childEnv(env))
+		// roll back to the running image, which is kept by the kernel even if its file is replaced
+		that.Logger.Error("Exec reload failed, restarting current image!", "phase", GraceExiting, "err", err)
+		if state != nil {
+			state.Seek(0, 0)
+		}
+		if e := syscall.Exec(SelfExe, append([]string{ex}, os.Args[1:]...), childEnv(env)); e != nil {
+			return fmt.Errorf("exec: %s, restart: %s", err.Error(), e.Error())
+		}
+		return nil
+	}
+	return nil
+}
+
+// SelfExe is the image of current process
+const SelfExe = "/proc/self/exe"
+
+// checkExecutable return an error if the file cannot be executed
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("exec: %s", err.Error())
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("exec: %s is not a regular file", path)
+	}
+	if err := unix.Access(path, unix.X_OK); err != nil {
+		return fmt.Errorf("exec: %s is not executable: %s", path, err.Error())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("exec: %s", err.Error())
+	}
+	defer f.Close()
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(f, head); err != nil || (string(head) != "\x7fELF" && string(head[:2]) != "#!") {
+		return fmt.Errorf("exec: %s is not an executable format", path)
+	}
+	return nil
+}
+
+// exit terminate current process, the process image is replaced instead in exec reload
+func (that *Grace) exit(code int) {
+	if that.execReload != nil {
+		err := that.execReload()
+		that.Logger.Error("Exec reload failed!", "phase", GraceExiting, "err", err)
+		code = 1
+	}
+	that.removePidFile() // kept by exec reload, the pid does not change
+	if that.onExit != nil {
+		// RunApps returns instead
+		that.onExit(code)
+		runtime.Goexit()
+	}
+	os.Exit(code)
+}
+
+// execReloaded return true if current process is replaced in place by its previous generation
+func (that *Grace) execReloaded() bool {
+	return that.Manifest != nil && that.Manifest.ParentPid == os.Getpid()
+}
@@ -1,43 +1,154 @@
 package gkgrace
 
 import (
-	"crypto/md5"
+	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/gogf/gf/container/gmap"
+	"github.com/gogf/gf/container/gtype"
 	"github.com/gogf/gf/os/genv"
-	"github.com/moqsien/processes/logger"
+	"github.com/gogf/gf/v2/container/garray"
 	"github.com/moqsien/processes/signals"
 )
 
 // Grace gracefully restart is supported only when use tcp and unix domain socket
 type Grace struct {
-	Status             GraceStatus    // status of current process
-	Listeners          *Container     // Listeners
-	IsChild            bool           // true if in child process
-	IsMulti            bool           // true if in multi process mode
-	Signal             chan os.Signal // listen for signals
-	MaxWaitTime        time.Duration  // maximum wait time
-	SingleExitingHook  Hook           // exiting hooks for single-process mode
-	MultiChildExitHook Hook           // child process exiting hooks for multi-process mode
-	MultiExitingHook   Hook           // master process exiting hooks for multi-process mode
-	MultiReloadHook    Hook           // reloading hooks for multi-process mode
+	Status             *StateMachine    // status of current process
+	Generation         int              // 0 for the first process, increased by every reload
+	Metrics            MetricsCollector // metrics collector, no-op by default, set it by SetMetrics
+	Logger             Logger           // structured logger, with pid and generation fields
+	LogLevel           LogLevel         // log level, inherited by child processes
+	Listeners          *Container       // Listeners
+	Manifest           *HandoffManifest // manifest inherited from parent, nil if not a child
+	ManifestViaFd      bool             // pass manifest to child through an inherited fd instead of env
+	IsChild            bool             // true if in child process
+	IsMulti            bool             // true if in multi process mode
+	Signal             chan os.Signal   // listen for signals
+	MaxWaitTime        time.Duration    // maximum wait time
+	LameDuck           time.Duration    // keep serving for a while before draining on exit signals
+	MaxStateSize       int64            // maximum size of application state passed to child
+	StateTimeout       time.Duration    // maximum time for passing application state to child
+	ConnHandoff        bool             // hand off established connections to child during reload
+	Workers            int              // number of worker processes in multi-process mode, for the hooks starting them
+	ReloadSignal       os.Signal        // reloads the process in addition to SIGUSR2
+	PidFile            string           // pid of the serving process is written to it, the supervisor in supervisor mode
+	Config             *Config          // config applied by ApplyConfig
+	ConfigFile         string           // config file loaded by NewFromConfig
+	ControlSocket      string           // path of unix control socket, a new process started with the same path upgrades from the old one
+	UpgradeTimeout     time.Duration    // maximum time an upgrading process may take before asking to drain
+	ReloadMode         ReloadMode       // how single-process mode reloads, fork by default
+	TicketKeyRotation  time.Duration    // rotation interval of TLS session ticket keys, 0 to disable rotation
+	WatchInterval      time.Duration    // interval of checking watched files, see Watch
+	WatchDebounce      time.Duration    // a changed file must stay unchanged for it before reloading
+	IsSupervisor       bool             // true in supervisor process, which owns listeners and starts workers
+	Supervised         bool             // true in worker processes started by a supervisor
+	SingleExitingHook  Hook             // exiting hooks for single-process mode
+	MultiChildExitHook Hook             // child process exiting hooks for multi-process mode
+	MultiExitingHook   Hook             // master process exiting hooks for multi-process mode
+	MultiReloadHook    Hook             // reloading hooks for multi-process mode
+	MultiLameDuckHook  Hook             // forwards lame-duck of master to child processes in multi-process mode
+	runningHooks       *gmap.StrAnyMap  // start time of running hooks
+	exporters          *gmap.StrAnyMap  // state exporters by name
+	importers          *gmap.StrAnyMap  // state importers by name
+	importOnce         sync.Once
+	stateExported      chan struct{} // closed when state is written to child
+	connExporters      []ConnExporter
+	connAdopters       *gmap.StrAnyMap // connection adopters by listener name
+	connSock           *net.UnixConn   // sends connections to child
+	controls           *gmap.StrAnyMap // control handlers by command
+	controlListener    *net.UnixListener
+	upgradeConn        *ControlConn  // connection to the process upgraded from
+	execReload         func() error  // replaces process image after draining in exec reload mode
+	workerConn         *net.UnixConn // supervisor end of the socketpair of the last started worker
+	supervisorConn     net.Conn      // worker end of the socketpair
+	supervisorReader   *bufio.Reader // reads supervisorConn
+	certsMu            sync.Mutex
+	certsByFiles       map[string]*CertManager // managers of files, shared by configs of the same files
+	certManagers       *garray.Array           // every CertManager, reloaded by "RELOAD-CERTS"
+	tickets            *ticketKeys
+	onExit             func(code int)               // set by RunApps, which returns instead of exiting
+	onAppError         func(name string, err error) // set by RunApps, which stops all apps
+	configHooks        *garray.Array                // called after config is reloaded in place
+	configMu           sync.Mutex
+	watchFiles         []string // files watched besides the executable and RestartFile, nil if not watching
+	watching           bool
+	services           []*AppContainer // services without listener, see AddService
+	started            []*AppContainer // started services in order of starting
+	servicesMu         sync.Mutex
+	cancelMetrics      func()
+	metrics            *gtype.Interface // MetricsCollector seen by listeners, SetMetrics may be called after they are got
 }
 
 func New() *Grace {
-	return &Grace{
-		Status:      GraceUnKnown,
-		Listeners:   NewContainer(),
-		IsChild:     IsChildProcess,
-		Signal:      make(chan os.Signal),
-		MaxWaitTime: DefualtMaxWaitTime,
+	g := &Grace{
+		Status:            NewStateMachine(),
+		Listeners:         NewContainer(),
+		IsChild:           IsChildProcess,
+		Supervised:        IsSupervised,
+		Generation:        CurrentGeneration,
+		Metrics:           noopMetrics{},
+		metrics:           gtype.NewInterface(collector{noopMetrics{}}),
+		LogLevel:          CurrentLogLevel,
+		runningHooks:      gmap.NewStrAnyMap(true),
+		exporters:         gmap.NewStrAnyMap(true),
+		importers:         gmap.NewStrAnyMap(true),
+		connAdopters:      gmap.NewStrAnyMap(true),
+		controls:          gmap.NewStrAnyMap(true),
+		certManagers:      garray.NewArray(true),
+		configHooks:       garray.NewArray(true),
+		MaxStateSize:      DefaultMaxStateSize,
+		StateTimeout:      DefaultStateTimeout,
+		Signal:            make(chan os.Signal),
+		MaxWaitTime:       DefualtMaxWaitTime,
+		ControlSocket:     genv.Get(GraceEnvControlSock),
+		UpgradeTimeout:    DefaultUpgradeTimeout,
+		TicketKeyRotation: DefaultTicketKeyRotation,
+		WatchInterval:     DefaultWatchInterval,
+		WatchDebounce:     DefaultWatchDebounce,
 	}
+	g.SetLogger(DefaultLogger)
+	// child processes of multi-process mode handle it in WaitForMulti
+	signal.Ignore(LameDuckSignal)
+	if g.IsChild {
+		m, err := LoadManifest()
+		if err == nil && m != nil {
+			err = m.Validate(g.Generation)
+		}
+		if err != nil {
+			g.Logger.Error("handoff manifest is invalid, inherited files are ignored", "err", err)
+			m = nil
+		}
+		g.Manifest = m
+	} else if g.ControlSocket != "" {
+		if ok, err := g.upgrade(); err != nil {
+			g.Logger.Error("upgrade failed, starting without inherited files", "socket", g.ControlSocket, "err", err)
+		} else if ok {
+			g.SetLogger(DefaultLogger) // generation changed
+			g.Logger.Info("upgrading from old process", "parent", g.Manifest.ParentPid, "files", len(g.Manifest.Files))
+		}
+	}
+	g.initControl()
+	g.initSystemd()
+	g.initTicketKeys()
+	g.OnTransition(GraceHandedOff, func(Transition) {
+		if !g.IsSupervisor {
+			g.Listeners.keepSocketFiles()
+		}
+	})
+	g.Subscribe(func(t Transition) {
+		g.Logger.Debug("status changed", "from", t.From, "to", t.To, "cause", t.Cause)
+	})
+	return g
 }
 
 func GkListen(addr *Address) (net.Listener, error) {
@@ -48,17 +159,46 @@ func GkListen(addr *Address) (net.Listener, error) {
 
 	switch addr.Network {
 	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
-		l, err = net.Listen(addr.Network, addr.Addr())
+		if strings.HasPrefix(addr.Network, "unix") {
+			removeStaleSocket(addr)
+		}
+		lc := net.ListenConfig{KeepAlive: addr.KeepAlive, Control: addr.control}
+		l, err = lc.Listen(context.Background(), addr.Network, addr.Addr())
 		if err != nil {
-			logger.Errorf("Listen Errored! err: %s", err.Error())
 			return nil, err
 		}
+		if strings.HasPrefix(addr.Network, "unix") && addr.SockMode != 0 {
+			if err = os.Chmod(addr.Sock, addr.SockMode); err != nil {
+				l.Close()
+				return nil, err
+			}
+		}
 	default:
 		return nil, fmt.Errorf("Network: %s is not supported!", addr.Network)
 	}
 	return l, nil
 }
 
+// removeStaleSocket remove socket file left by a process inherited it, sockets in use are kept
+func removeStaleSocket(addr *Address) {
+	if _, err := os.Stat(addr.Sock); err != nil {
+		return
+	}
+	if c, err := net.Dial(addr.Network, addr.Sock); err == nil {
+		c.Close()
+		return
+	}
+	os.Remove(addr.Sock)
+}
+
+func GkListenPacket(addr *Address) (net.PacketConn, error) {
+	if !addr.IsPacket() {
+		return nil, fmt.Errorf("Network: %s is not supported!", addr.Network)
+	}
+	lc := net.ListenConfig{Control: addr.control}
+	return lc.ListenPacket(context.Background(), addr.Network, addr.Addr())
+}
+
 // SetToMulti enable multi-process mode
 func (that *Grace) SetToMulti() {
 	that.IsMulti = true
@@ -69,25 +209,95 @@ func (that *Grace) SetMaxWait(t time.Duration) {
 	that.MaxWaitTime = t
 }
 
+// SetLogger set structured logger, pid and generation fields are added, the level of l is kept,
+// loggers of NewLogger are copied, so that SetLogLevel does not change l
+func (that *Grace) SetLogger(l Logger) {
+	if c, ok := l.(*levelLogger); ok {
+		l = c.copy()
+	}
+	that.Logger = l.With("pid", os.Getpid(), "generation", that.Generation)
+}
+
+// SetLogLevel set log level of current process and its children
+func (that *Grace) SetLogLevel(level LogLevel) {
+	that.LogLevel = level
+	if s, ok := that.Logger.(LevelSetter); ok {
+		s.SetLevel(level)
+	}
+}
+
+// SetLameDuck set lame-duck period, during it the process keeps serving but reports not-ready
+func (that *Grace) SetLameDuck(t time.Duration) {
+	that.LameDuck = t
+}
+
+// Ready return true if current process is ready to accept new requests, like Readiness
+func (that *Grace) Ready() bool {
+	return isReady(that.Status.Get())
+}
+
+// Subscribe call fn on every status transition of current process
+func (that *Grace) Subscribe(fn TransitionFunc) (cancel func()) {
+	return that.Status.Subscribe(fn)
+}
+
+// OnTransition call fn when status of current process is changed to "to"
+func (that *Grace) OnTransition(to GraceStatus, fn TransitionFunc) (cancel func()) {
+	return that.Status.OnTransition(to, fn)
+}
+
+// setStatus change status and log invalid transitions
+func (that *Grace) setStatus(to GraceStatus, cause string) {
+	if err := that.Status.Transit(to, cause); err != nil {
+		that.Logger.Error("status transition failed", "phase", to, "err", err)
+	}
+}
+
 // Register register a listener before running
 func (that *Grace) Register(a IAddress) error {
-	addr := a.GetAddr()
+	addrs := []*Address{a.GetAddr()}
+	if m, ok := a.(IAddresses); ok {
+		addrs = m.GetAddrs()
+	}
+	that.Status.TransitFrom(GraceUnKnown, GraceStarting, "register")
+	names := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if names[addr.String()] {
+			return fmt.Errorf("Address: %s is duplicated!", addr.String())
+		}
+		names[addr.String()] = true
+		if err := that.register(addr); err != nil {
+			return err
+		}
+	}
+	a.SetGrace(that)
+	return nil
+}
+
+// register prepare listener of an address, every address is inherited and drained on its own
+func (that *Grace) register(addr *Address) error {
 	if addr.Host == "" && addr.Sock != "" {
 		addr.Host = "0.0.0.0"
 	}
-	// listener is initialized only in master process for multi-process mode
-	if !that.IsChild && that.IsMulti {
-		l, err := GkListen(addr)
-		if l != nil {
-			that.Listeners.Add(addr.String(), l)
-			a.SetGrace(that)
+	// listener is initialized only in master process for multi-process mode and in supervisor
+	if !that.IsChild && (that.IsMulti || that.IsSupervisor) {
+		var (
+			l   any
+			err error
+		)
+		if addr.IsPacket() {
+			l, err = GkListenPacket(addr)
+		} else {
+			l, err = GkListen(addr)
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		return that.Listeners.Add(addr.String(), l)
 	} else {
 		switch addr.Network {
-		case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		case "tcp", "tcp4", "tcp6", "unix", "unixpacket", "udp", "udp4", "udp6", "unixgram":
 			that.Listeners.AddNull(addr.String())
-			a.SetGrace(that)
 			return nil
 		default:
 			return fmt.Errorf("Network: %s is not supported!", addr.Network)
@@ -96,97 +306,363 @@ func (that *Grace) Register(a IAddress) error {
 }
 
 func (that *Grace) GetListener(a IAddress) (l net.Listener) {
+	var err error
 	addr := a.GetAddr()
+	that.Status.TransitFrom(GraceUnKnown, GraceStarting, "listen")
+	if that.IsSupervisor {
+		that.Logger.Info("listener is served by workers", "listener", addr.String())
+		return nil
+	}
 	if that.IsMulti {
 
 	} else {
 		// single-process mode
 		if !that.IsChild {
 			// master
-			l, _ = GkListen(addr)
+			l, err = GkListen(addr)
 		} else {
 			// child
 			if offset := that.GetOffsetFromEnv(addr); offset != -1 {
-				logger.Printf("[offset]: %d, file inherited from [parent]: %d", offset, os.Getppid())
-				l, _ = net.FileListener(os.NewFile(uintptr(offset), addr.String()))
+				// inherited from parent
+				that.Logger.Info("file inherited from parent", "listener", addr.String(), "offset", offset, "parent", that.Manifest.ParentPid)
+				f := os.NewFile(uintptr(offset), addr.String())
+				l, err = net.FileListener(f)
+				f.Close()
 			} else {
-				l, _ = GkListen(addr)
+				l, err = GkListen(addr)
 			}
 		}
 	}
+	if err != nil {
+		that.Logger.Error("Listen Errored!", "listener", addr.String(), "err", err)
+	}
 	if l != nil {
-		that.Listeners.Add(addr.String(), l) // register listener
+		// register listener
+		if err := that.Listeners.Add(addr.String(), l); err != nil {
+			that.Logger.Error("Listener is not registered!", "listener", addr.String(), "err", err)
+		}
+		if that.IsChild && that.ConnHandoff && !that.connAdopters.Contains(addr.String()) {
+			al := newAdoptingListener(l)
+			that.OnConnAdopt(addr.String(), al.adopt)
+			l = al
+		}
+		l = &countingListener{Listener: l, name: addr.String(), metrics: that.metrics}
+	}
+	return
+}
+
+// GetPacketConn get a udp or unixgram conn, inherited from parent if possible
+func (that *Grace) GetPacketConn(a IAddress) (c net.PacketConn) {
+	var err error
+	addr := a.GetAddr()
+	that.Status.TransitFrom(GraceUnKnown, GraceStarting, "listen")
+	if that.IsSupervisor {
+		that.Logger.Info("listener is served by workers", "listener", addr.String())
+		return nil
+	}
+	if f, found := that.Manifest.Lookup(addr.String()); found && f.Kind == KindPacketConn {
+		that.Logger.Info("file inherited from parent", "listener", addr.String(), "fd", f.Fd, "parent", that.Manifest.ParentPid)
+		file := os.NewFile(uintptr(f.Fd), addr.String())
+		c, err = net.FilePacketConn(file)
+		file.Close()
+	} else {
+		c, err = GkListenPacket(addr)
+	}
+	if err != nil {
+		that.Logger.Error("Listen Errored!", "listener", addr.String(), "err", err)
+	}
+	if c != nil {
+		if err := that.Listeners.Add(addr.String(), c); err != nil {
+			that.Logger.Error("Listener is not registered!", "listener", addr.String(), "err", err)
+		}
 	}
 	return
 }
 
 // GetExtrafiles get extrafiles that child process will inherite from
 func (that *Grace) GetExtrafiles() (result []*os.File) {
+	result, _ = that.prepareHandoff(that.Generation + 1)
+	return
+}
+
+// handoff is everything passed to the next generation
+type handoff struct {
+	manifest *HandoffManifest
+	files    []*os.File // ExtraFiles of child process
+	started  []func()   // called after child process is started
+	aborted  []func()   // called if child process is not started
+}
+
+// addFile pass f to child process as a plain file
+func (that *handoff) addFile(name string, f *os.File) {
+	that.files = append(that.files, f)
+	that.manifest.Add(HandoffFile{Name: name, Kind: KindFile})
+}
+
+func (that *handoff) done(started bool) {
+	fns := that.aborted
+	if started {
+		fns = that.started
+	}
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// prepareHandoff collect files inherited by child process and describe them in a manifest
+func (that *Grace) prepareHandoff(generation int) (files []*os.File, m *HandoffManifest) {
+	m = NewManifest(generation)
 	that.Listeners.Names.Iterator(func(_ int, v string) bool {
-		l := that.Listeners.Data.Get(v)
-		switch l.(type) {
-		case *net.TCPListener:
-			file, _ := l.(*net.TCPListener).File()
-			result = append(result, file)
-		case *net.UnixListener:
-			file, _ := l.(*net.UnixListener).File()
-			result = append(result, file)
-		default:
-			fmt.Printf("Listener : %v is not supported!", l)
+		f, desc, err := that.Listeners.File(v)
+		if err != nil {
+			that.Logger.Error("Listener is not inherited!", "listener", v, "err", err)
+			return true
 		}
+		files = append(files, f)
+		m.Add(desc)
 		return true
 	})
 	return
 }
 
-// GenerateOffsets generate extrafiles offsets map for single-process mode
-func (that *Grace) GenerateOffsets() map[string]string {
-	offsets := make(map[string]string)
-	that.Listeners.Names.Iterator(func(k int, v string) bool {
-		key := fmt.Sprintf("%x", md5.Sum([]byte(v)))
-		offsets[key] = strconv.Itoa(k + DefaultOffset)
-		return true
-	})
-	return offsets
+// GetOffsetFromEnv read fd of an inherited listener from manifest, return -1 if not found
+func (that *Grace) GetOffsetFromEnv(addr *Address) (offset int) {
+	if f, found := that.Manifest.Lookup(addr.String()); found && f.Kind == KindListener {
+		return f.Fd
+	}
+	return -1
 }
 
-// GetOffsetFromEnv read extrafile offset from environment for single-process mode
-func (that *Grace) GetOffsetFromEnv(addr *Address) (offset int) {
-	envName := fmt.Sprintf("%x", md5.Sum([]byte(addr.String())))
-	return genv.GetVar(envName, -1).Int()
+// childEnv return environment of child process, empty values remove the variable
+func childEnv(overrides map[string]string) (env []string) {
+	for _, kv := range os.Environ() {
+		if _, found := overrides[strings.SplitN(kv, "=", 2)[0]]; !found {
+			env = append(env, kv)
+		}
+	}
+	for k, v := range overrides {
+		if v != "" {
+			env = append(env, k+"="+v)
+		}
+	}
+	return
+}
+
+// newChildCmd create command of the next generation, h.files should be closed after it starts
+func (that *Grace) newChildCmd() (cmd *exec.Cmd, h *handoff, err error) {
+	h = &handoff{}
+	ex, err := os.Executable()
+	if err != nil {
+		return nil, h, err
+	}
+	h.files, h.manifest = that.prepareHandoff(that.Generation + 1)
+	if err = that.prepareStateExport(h); err != nil {
+		return nil, h, err
+	}
+	if err = that.prepareConnHandoff(h); err != nil {
+		return nil, h, err
+	}
+	if err = that.prepareSupervision(h); err != nil {
+		return nil, h, err
+	}
+	content, err := h.manifest.Encode()
+	if err != nil {
+		return nil, h, err
+	}
+	env := that.nextEnv(h.manifest)
+	env[GraceEnvManifest] = string(content)
+	if that.IsSupervisor {
+		env[GraceEnvSupervised] = "true"
+	}
+	if that.ManifestViaFd {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, h, err
+		}
+		h.files = append(h.files, r)
+		env[GraceEnvManifest] = ""
+		env[GraceEnvManifestFd] = strconv.Itoa(len(h.files) - 1 + DefaultOffset)
+		go func() {
+			w.Write(content)
+			w.Close()
+		}()
+	}
+	cmd = exec.Command(ex)
+	cmd.Args = []string{ex}
+	cmd.Args = append(cmd.Args, os.Args[1:]...)
+	cmd.ExtraFiles = h.files
+	cmd.Env = childEnv(env)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = WorkingDir
+	return cmd, h, nil
+}
+
+// nextEnv return environment overrides of the next generation, manifest is not included
+func (that *Grace) nextEnv(m *HandoffManifest) map[string]string {
+	return map[string]string{
+		GraceEnvIsChild:    "true", // to mark the child process by "true"
+		GraceEnvGeneration: strconv.Itoa(m.Generation),
+		GraceEnvLogLevel:   that.LogLevel.String(),
+		GraceEnvManifest:   "",
+		GraceEnvManifestFd: "",
+	}
 }
 
 // ReloadSingle reload process for single-process mode
-func (that *Grace) ReloadSingle() {
+func (that *Grace) ReloadSingle() error {
 	if !that.IsMulti {
-		ex, _ := os.Executable()
-		cmd := exec.Command(ex)
-		cmd.Args = []string{ex}
-		cmd.Args = append(cmd.Args, os.Args[1:]...)
-		cmd.ExtraFiles = that.GetExtrafiles()
-		genv.SetMap(map[string]string{GraceEnvIsChild: "true"}) // to mark the child process by "true"
-		genv.SetMap(that.GenerateOffsets())
-		cmd.Env = genv.All()
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = WorkingDir
+		cmd, h, err := that.newChildCmd()
+		defer that.closeFiles(h.files)
+		if err != nil {
+			h.done(false)
+			that.Logger.Error("Restart process failed!", "phase", GraceReloading, "err", err)
+			return err
+		}
 		// cmd.SysProcAttr = &syscall.SysProcAttr{Foreground: true, Noctty: false}
 		if err := cmd.Start(); err != nil {
-			logger.Errorf("Restart process failed: %s", err.Error())
+			h.done(false)
+			that.Logger.Error("Restart process failed!", "phase", GraceReloading, "err", err)
+			return err
 		}
+		h.done(true)
+		go func() {
+			// rollback if child exits before taking over
+			err := cmd.Wait()
+			if that.Status.TransitFrom(GraceReloading, GraceRunning, "child exited before handoff") {
+				that.Logger.Error("Child exited before handoff!", "phase", GraceReloading, "child", cmd.Process.Pid, "err", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// closeFiles close duplicated files after they are passed to child, registered plain files are kept
+func (that *Grace) closeFiles(files []*os.File) {
+	owned := make(map[*os.File]bool)
+	if that.Listeners.Data != nil {
+		that.Listeners.Data.Iterator(func(_ string, v interface{}) bool {
+			if f, ok := v.(*os.File); ok {
+				owned[f] = true
+			}
+			return true
+		})
+	}
+	for _, f := range files {
+		if !owned[f] {
+			f.Close()
+		}
+	}
+}
+
+// writePidFile write pid of the serving process to PidFile, workers of supervisor and multi-process mode skip it
+func (that *Grace) writePidFile() {
+	if that.PidFile == "" || that.isWorker() {
+		return
+	}
+	if err := os.WriteFile(that.PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		that.Logger.Error("cannot write pid file", "file", that.PidFile, "err", err)
+	}
+}
+
+// isWorker return true in processes started by supervisor or master of multi-process mode, they do not reload themselves
+func (that *Grace) isWorker() bool {
+	return that.Supervised || (that.IsMulti && that.IsChild)
+}
+
+// removePidFile remove PidFile if it is still of current process, a reloaded child owns it otherwise
+func (that *Grace) removePidFile() {
+	if that.PidFile == "" {
+		return
+	}
+	if b, err := os.ReadFile(that.PidFile); err == nil && strings.TrimSpace(string(b)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(that.PidFile)
+	}
+}
+
+// mapSignal map ReloadSignal to SIGUSR2
+func (that *Grace) mapSignal(sig os.Signal) os.Signal {
+	if that.ReloadSignal != nil && sig == that.ReloadSignal {
+		return syscall.SIGUSR2
+	}
+	return sig
+}
+
+// reload start a process reload in the signal loop like SIGUSR2, without forwarding it to supervisor
+func (that *Grace) reload() error {
+	switch {
+	case that.Supervised:
+		return fmt.Errorf("reload: workers are reloaded by supervisor")
+	case that.IsMulti && that.IsChild:
+		return fmt.Errorf("reload: children are reloaded by master")
+	}
+	go func() { that.Signal <- syscall.SIGUSR2 }()
+	return nil
+}
+
+// notifyReloadSignal relay ReloadSignal to Signal, and SIGHUP if a config file is loaded
+func (that *Grace) notifyReloadSignal() {
+	if that.ReloadSignal != nil {
+		signal.Notify(that.Signal, that.ReloadSignal)
+	}
+	if that.ConfigFile != "" {
+		signal.Notify(that.Signal, syscall.SIGHUP)
 	}
 }
 
 // NotifyParent notify parent process to exit in child
 func (that *Grace) NotifyParent() {
+	if that.upgradeConn != nil {
+		that.notifyUpgraded()
+		return
+	}
+	if that.Supervised {
+		that.notifySupervisor()
+		return
+	}
+	if that.execReloaded() {
+		that.Logger.Info("Gracefully restarted in place", "mode", ReloadExec)
+		return
+	}
 	if IsChildProcess {
 		parentPid := syscall.Getppid()
 		if parentPid != 1 {
 			if err := signals.KillPid(parentPid, signals.ToSignal("SIGTERM"), false); err != nil {
-				logger.Errorf("failed to send signal to parent process, error: %s", err.Error())
+				that.Logger.Error("failed to send signal to parent process", "parent", parentPid, "err", err)
 				return
 			}
-			logger.Printf("Gracefully restarting, child[%d] sent 'SIGTERM' to parent[%d]", syscall.Getpid(), parentPid)
+			that.Logger.Info("Gracefully restarting, sent 'SIGTERM' to parent", "parent", parentPid)
+		}
+	}
+}
+
+// waitLameDuck wait for lame-duck period, a second exiting signal ends it at once
+func (that *Grace) waitLameDuck(cause string) {
+	if that.LameDuck <= 0 || !that.Status.TransitFrom(GraceRunning, GraceLameDuck, cause) {
+		return
+	}
+	that.Logger.Info("lame-duck before draining", "phase", GraceLameDuck, "period", that.LameDuck)
+	if that.IsMulti && !that.IsChild {
+		// child processes serve requests, they report not-ready too
+		if that.MultiLameDuckHook == nil {
+			that.Logger.Warn("'MultiLameDuckHook' is not set, child processes keep reporting ready!")
+		} else if err := that.runHook("lameDuck", that.MultiLameDuckHook); err != nil {
+			that.Logger.Error("'lameDuck' execution failed!", "phase", GraceLameDuck, "err", err)
+		}
+	}
+	timer := time.NewTimer(that.LameDuck)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return
+		case sig := <-that.Signal:
+			switch sig {
+			case syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGABRT:
+				that.Logger.Info("lame-duck interrupted", "phase", GraceLameDuck, "signal", sig.String())
+				return
+			default:
+			}
 		}
 	}
 }
@@ -202,30 +678,70 @@ func (that *Grace) WaitForSingle() {
 		syscall.SIGUSR1,
 		syscall.SIGUSR2,
 	)
+	that.notifyReloadSignal()
 	for {
-		sig := <-that.Signal
+		sig := that.mapSignal(<-that.Signal)
 		if that.SingleExitingHook == nil {
 			that.SingleExitingHook = func() error {
-				logger.Printf("[Parent]: %d, is exiting...", os.Getpid())
-				os.Exit(0)
+				that.Logger.Info("process is exiting...", "phase", GraceExiting)
+				that.stopServices()
+				that.setStatus(GraceExiting, "exit")
+				that.setStatus(GraceStopped, "exited")
+				that.exit(0)
 				return nil
 			}
 		}
 		switch sig {
 		case syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGABRT:
+			if that.Status.TransitFrom(GraceReloading, GraceHandedOff, "signal: "+sig.String()) {
+				that.waitStateExport()
+				that.exportConns()
+			} else {
+				that.waitLameDuck("signal: " + sig.String())
+				that.MaxWaitTime = time.Second // force to exit within 1 second, a handed off parent drains fully.
+			}
 			signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
-			that.Status = GraceExiting
-			that.MaxWaitTime = time.Second // force to exit within 1 second.
 			that.SingleExitingHook()
 			continue
 		case syscall.SIGQUIT:
+			if that.Status.TransitFrom(GraceReloading, GraceHandedOff, "signal: "+sig.String()) {
+				that.waitStateExport()
+				that.exportConns()
+			} else {
+				that.waitLameDuck("signal: " + sig.String())
+			}
 			signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
-			that.Status = GraceExiting
 			that.SingleExitingHook()
 			continue
+		case syscall.SIGHUP:
+			if err := that.ReloadConfig(); err != nil {
+				that.Logger.Error("cannot reload config", "err", err)
+			}
+			continue
 		case syscall.SIGUSR2:
-			that.ReloadSingle()
-			that.Status = GraceReloading
+			if that.Supervised {
+				// workers are replaced by supervisor, their parent, Manifest is nil if nothing is inherited
+				syscall.Kill(os.Getppid(), syscall.SIGUSR2)
+				continue
+			}
+			if err := that.Status.Transit(GraceReloading, "signal: "+sig.String()); err != nil {
+				that.Logger.Error("cannot reload", "err", err)
+				continue
+			}
+			if that.ReloadMode == ReloadExec {
+				if err := that.prepareExec(); err != nil {
+					that.Logger.Error("Exec reload failed!", "phase", GraceReloading, "err", err)
+					that.setStatus(GraceRunning, "reload failed: "+err.Error())
+					continue
+				}
+				that.setStatus(GraceHandedOff, "exec reload")
+				signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
+				that.SingleExitingHook()
+				continue
+			}
+			if err := that.ReloadSingle(); err != nil {
+				that.setStatus(GraceRunning, "reload failed: "+err.Error())
+			}
 			continue
 		default:
 		}
@@ -233,7 +749,6 @@ func (that *Grace) WaitForSingle() {
 }
 
 func (that *Grace) WaitForMulti() {
-	pid := os.Getpid()
 	if that.IsChild {
 		signal.Notify(
 			that.Signal,
@@ -242,13 +757,17 @@ func (that *Grace) WaitForMulti() {
 			syscall.SIGKILL,
 			syscall.SIGTERM,
 			syscall.SIGABRT,
+			LameDuckSignal,
 		)
 		for {
 			sig := <-that.Signal
-			logger.Printf("[Child process]: %d, recieve [signal]: %s", pid, sig.String())
+			that.Logger.Info("child process received signal", "signal", sig.String())
 			if that.MultiChildExitHook == nil {
 				that.MultiChildExitHook = func() error {
-					logger.Printf("Child[%d] is exiting...", os.Getpid())
+					that.Logger.Info("child process is exiting...", "phase", GraceExiting)
+					that.stopServices()
+					that.setStatus(GraceExiting, "exit")
+					that.setStatus(GraceStopped, "exited")
 					os.Exit(0)
 					return nil
 				}
@@ -263,6 +782,10 @@ func (that *Grace) WaitForMulti() {
 				signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
 				that.MultiChildExitHook()
 				continue
+			case LameDuckSignal:
+				// master is in lame-duck, keep serving until it stops the child
+				that.Status.TransitFrom(GraceRunning, GraceLameDuck, "master lame-duck")
+				continue
 			default:
 			}
 		}
@@ -277,29 +800,53 @@ func (that *Grace) WaitForMulti() {
 			syscall.SIGUSR1,
 			syscall.SIGUSR2,
 		)
+		that.notifyReloadSignal()
 		for {
-			sig := <-that.Signal
-			logger.Printf("[Master process]: %d recieve [signal]: %s", pid, sig.String())
+			sig := that.mapSignal(<-that.Signal)
+			that.Logger.Info("master process received signal", "signal", sig.String())
 			switch sig {
 			case syscall.SIGINT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM:
-				signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
-				that.MaxWaitTime = time.Second // force to exit within 1 second.
 				if that.MultiExitingHook == nil {
-					logger.Errorf("'MultiExitingHook' is not set!")
+					signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
+					that.Logger.Error("'MultiExitingHook' is not set!")
 					continue
 				}
+				that.waitLameDuck("signal: " + sig.String())
+				signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
+				that.MaxWaitTime = time.Second // force to exit within 1 second.
+				that.setStatus(GraceDraining, "signal: "+sig.String())
 				that.MultiExitingHook()
 				continue
 			case syscall.SIGQUIT:
-				signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
 				if that.MultiExitingHook == nil {
-					logger.Errorf("'MultiExitingHook' is not set!")
+					signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
+					that.Logger.Error("'MultiExitingHook' is not set!")
 					continue
 				}
+				that.waitLameDuck("signal: " + sig.String())
+				signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGABRT, syscall.SIGTERM)
+				that.setStatus(GraceDraining, "signal: "+sig.String())
 				that.MultiExitingHook()
 				continue
+			case syscall.SIGHUP:
+				if err := that.ReloadConfig(); err != nil {
+					that.Logger.Error("cannot reload config", "err", err)
+				}
+				continue
 			case syscall.SIGUSR2:
-				that.MultiReloadHook()
+				if that.MultiReloadHook == nil {
+					that.Logger.Error("'MultiReloadHook' is not set!")
+					continue
+				}
+				if err := that.Status.Transit(GraceReloading, "signal: "+sig.String()); err != nil {
+					that.Logger.Error("cannot reload", "err", err)
+					continue
+				}
+				if err := that.runHook("reload", that.MultiReloadHook); err != nil {
+					that.setStatus(GraceRunning, "reload failed: "+err.Error())
+				} else {
+					that.setStatus(GraceRunning, CauseReloaded)
+				}
 				continue
 			default:
 			}
@@ -307,12 +854,50 @@ func (that *Grace) WaitForMulti() {
 	}
 }
 
+// runServices start services, the process exits if they fail
+func (that *Grace) runServices() bool {
+	if err := that.startServices(); err != nil {
+		that.Logger.Error("services failed to start, exiting", "err", err)
+		go func() { that.Signal <- syscall.SIGTERM }()
+		return false
+	}
+	return true
+}
+
 // Wait wait for signal to come
 func (that *Grace) Wait() {
+	that.Status.TransitFrom(GraceUnKnown, GraceStarting, "wait")
+	if err := that.ServeControl(); err != nil {
+		that.Logger.Error("control socket is disabled", "err", err)
+	}
+	if that.IsSupervisor {
+		that.writePidFile()
+		go that.watch()
+		that.WaitForSupervisor() // running once a worker is ready
+		return
+	}
 	if that.IsMulti {
+		// services run in child processes
+		if !that.IsChild || that.runServices() {
+			that.setStatus(GraceRunning, "wait")
+			that.writePidFile()
+			go that.watch()
+		}
 		that.WaitForMulti()
 	} else {
-		that.NotifyParent()
+		if that.IsChild {
+			if err := that.ImportState(); err != nil {
+				that.Logger.Error("state import failed", "err", err)
+			}
+			go that.AdoptConns()
+		}
+		// ready only after services are started, parent keeps serving if they fail during a reload
+		if that.runServices() {
+			that.setStatus(GraceRunning, "wait")
+			that.writePidFile()
+			go that.watch()
+			that.NotifyParent()
+		}
 		that.WaitForSingle()
 	}
 }
@@ -0,0 +1,79 @@
+package gkgrace
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// default paths of health endpoints
+const (
+	DefaultReadyPath = "/readyz"
+	DefaultLivePath  = "/healthz"
+)
+
+// Readiness return status code and text for readiness probes, the process keeps serving while reloading
+// and after handing off, it flips to 503 as soon as lame-duck, draining or exiting begins
+func (that *Grace) Readiness() (int, string) {
+	s := that.Status.Get()
+	if isReady(s) {
+		return http.StatusOK, s.String()
+	}
+	return http.StatusServiceUnavailable, s.String()
+}
+
+// isReady tell if a process in status s accepts new requests, see Ready and Readiness
+func isReady(s GraceStatus) bool {
+	switch s {
+	case GraceRunning, GraceReloading, GraceHandedOff:
+		return true
+	}
+	return false
+}
+
+// Liveness return status code and text for liveness probes,
+// it fails when a hook runs longer than MaxWaitTime
+func (that *Grace) Liveness() (int, string) {
+	if name, found := that.stuckHook(); found {
+		return http.StatusServiceUnavailable, fmt.Sprintf("hook deadlocked: %s", name)
+	}
+	return http.StatusOK, "alive"
+}
+
+// ReadinessHandler is http.Handler for readiness probes
+func (that *Grace) ReadinessHandler() http.Handler {
+	return healthHandler(that.Readiness)
+}
+
+// LivenessHandler is http.Handler for liveness probes
+func (that *Grace) LivenessHandler() http.Handler {
+	return healthHandler(that.Liveness)
+}
+
+func healthHandler(probe func() (int, string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, text := probe()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write([]byte(text))
+	})
+}
+
+// ServeHealth serve health endpoints on a dedicated address, the listener is inherited like any other,
+// only single-process mode is supported, use UseHealth of apps in other modes
+func (that *Grace) ServeHealth(addr *Address) error {
+	if that.IsMulti {
+		return fmt.Errorf("ServeHealth: multi-process mode is not supported!")
+	}
+	if that.IsSupervisor || that.Supervised {
+		return fmt.Errorf("ServeHealth: supervisor mode is not supported!")
+	}
+	ln := that.GetListener(&innerAddr{addr: addr})
+	if ln == nil {
+		return fmt.Errorf("Cannot get a listener for health checks! ")
+	}
+	mux := http.NewServeMux()
+	mux.Handle(DefaultReadyPath, that.ReadinessHandler())
+	mux.Handle(DefaultLivePath, that.LivenessHandler())
+	go http.Serve(ln, mux)
+	return nil
+}
@@ -0,0 +1,37 @@
+package gkgrace
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	cases := []struct {
+		path []GraceStatus
+		code int
+	}{
+		{nil, http.StatusServiceUnavailable},
+		{[]GraceStatus{GraceStarting}, http.StatusServiceUnavailable},
+		{[]GraceStatus{GraceStarting, GraceRunning}, http.StatusOK},
+		{[]GraceStatus{GraceStarting, GraceRunning, GraceReloading}, http.StatusOK},
+		{[]GraceStatus{GraceStarting, GraceRunning, GraceReloading, GraceHandedOff}, http.StatusOK},
+		{[]GraceStatus{GraceStarting, GraceRunning, GraceLameDuck}, http.StatusServiceUnavailable},
+		{[]GraceStatus{GraceStarting, GraceRunning, GraceDraining}, http.StatusServiceUnavailable},
+		{[]GraceStatus{GraceStarting, GraceRunning, GraceExiting}, http.StatusServiceUnavailable},
+		{[]GraceStatus{GraceStarting, GraceRunning, GraceDraining, GraceStopped}, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		g := &Grace{Status: NewStateMachine()}
+		for _, s := range c.path {
+			if err := g.Status.Transit(s, "test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if code, text := g.Readiness(); code != c.code {
+			t.Errorf("Readiness() in %s = %d, want %d", text, code, c.code)
+		}
+		if ready := g.Ready(); ready != (c.code == http.StatusOK) {
+			t.Errorf("Ready() in %s = %v, want %v", g.Status.Get(), ready, !ready)
+		}
+	}
+}
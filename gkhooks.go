@@ -3,10 +3,15 @@ package gkgrace
 import (
 	"context"
 	"os"
-
-	"github.com/moqsien/processes/logger"
+	"syscall"
+	"time"
 )
 
+// LameDuckSignal is sent to child processes by MultiLameDuckHook, they report not-ready until they exit.
+// It is the real-time signal 36 (SIGRTMIN+2 of glibc), which the kernel and terminals never send on their own,
+// processes other than child processes of multi-process mode ignore it
+const LameDuckSignal = syscall.Signal(36)
+
 type sigChan chan struct{}
 
 type deferFunc func(ctx context.Context) sigChan
@@ -15,15 +20,56 @@ type deferFunc func(ctx context.Context) sigChan
 func (that *Grace) ExecuteWithTimeout(action string, df deferFunc) {
 	ctxTimeout, cancel := context.WithTimeout(context.Background(), that.MaxWaitTime)
 	defer cancel()
+	start := time.Now()
+	done := that.trackHook(action)
+	c := df(ctxTimeout)
 	select {
 	case <-ctxTimeout.Done():
-		if err := ctxTimeout.Err(); err != nil {
-			logger.Errorf("[process]: %d, [action]: %s, execute hooks timeout! error: %s", os.Getpid(), action, err.Error())
+		err := ctxTimeout.Err()
+		if err != nil {
+			that.Logger.Error("execute hooks timeout!", "action", action, "err", err)
 		}
-	case <-df(ctxTimeout):
+		that.Metrics.HookDuration(action, time.Since(start), err)
+		go func() {
+			<-c
+			done()
+		}()
+	case <-c:
+		that.Metrics.HookDuration(action, time.Since(start), nil)
+		done()
+	}
+}
+
+// runHook run a single hook and record its duration
+func (that *Grace) runHook(name string, h Hook) error {
+	start := time.Now()
+	done := that.trackHook(name)
+	defer done()
+	err := h()
+	that.Metrics.HookDuration(name, time.Since(start), err)
+	return err
+}
+
+// trackHook mark a hook as running until done is called
+func (that *Grace) trackHook(name string) (done func()) {
+	that.runningHooks.Set(name, time.Now())
+	return func() {
+		that.runningHooks.Remove(name)
 	}
 }
 
+// stuckHook return the name of a hook running longer than MaxWaitTime
+func (that *Grace) stuckHook() (name string, found bool) {
+	that.runningHooks.Iterator(func(k string, v interface{}) bool {
+		if time.Since(v.(time.Time)) > that.MaxWaitTime {
+			name, found = k, true
+			return false
+		}
+		return true
+	})
+	return
+}
+
 /*
   useful hooks for Grace
 */
@@ -31,18 +77,18 @@ func (that *Grace) ExecuteWithTimeout(action string, df deferFunc) {
 // SetExitHooksForSingle set hooks called when exiting for single-process mode
 func (that *Grace) SetExitHooksForSingle(beforeExit Hook, clearUp ...Hook) {
 	that.SingleExitingHook = func() error {
-		pid := os.Getpid()
-		defer os.Exit(0)
-		defer logger.Printf("[Pid]: %d exited.", pid)
+		defer that.exit(0)
+		defer that.Logger.Info("process exited.", "phase", GraceStopped)
+		defer that.setStatus(GraceStopped, "exited")
 
 		reloadFlag := false
-		if that.Status == GraceReloading {
+		if that.Status.Is(GraceHandedOff) {
 			reloadFlag = true
-			logger.Printf("[parent]: %d is exiting...\n", pid)
+			that.Logger.Info("parent is exiting...", "phase", GraceDraining)
 		} else {
-			logger.Printf("[process]: %d is exiting...\n", pid)
+			that.Logger.Info("process is exiting...", "phase", GraceDraining)
 		}
-		that.Status = GraceExiting
+		that.setStatus(GraceDraining, "exit hook")
 
 		exitFunc := func(ctx context.Context) sigChan {
 			c := make(sigChan)
@@ -50,18 +96,21 @@ func (that *Grace) SetExitHooksForSingle(beforeExit Hook, clearUp ...Hook) {
 				defer close(c)
 				if !reloadFlag && len(clearUp) > 0 {
 					// if this is not reloading, then do sth special for clearups
-					if err := clearUp[0](); err != nil {
-						logger.Errorf("[Pid]: %d, 'clearUp' execution failed! err: %s", pid, err.Error())
+					if err := that.runHook("clearUp", clearUp[0]); err != nil {
+						that.Logger.Error("'clearUp' execution failed!", "phase", GraceDraining, "err", err)
 					}
 				}
-				err := beforeExit()
+				err := that.runHook("beforeExit", beforeExit)
 				if err != nil {
-					logger.Errorf("[Pid]: %d, 'beforeExit' execution failed! err: %s", pid, err.Error())
+					that.Logger.Error("'beforeExit' execution failed!", "phase", GraceDraining, "err", err)
 				}
+				// services are stopped after apps are drained
+				that.stopServices()
 			}()
 			return c
 		}
 		that.ExecuteWithTimeout("exit", exitFunc)
+		that.setStatus(GraceExiting, "exit hook finished")
 		return nil
 	}
 }
@@ -74,12 +123,17 @@ func (that *Grace) SetReloadHooksForMulti(reload Hook) {
 	that.MultiReloadHook = reload
 }
 
+// SetLameDuckHooksForMulti set the hook forwarding lame-duck of master to child processes, like sending LameDuckSignal to them
+func (that *Grace) SetLameDuckHooksForMulti(lameDuck Hook) {
+	that.MultiLameDuckHook = lameDuck
+}
+
 func (that *Grace) SetExitHooksForMultiChild(beforeExit Hook, clearUp ...Hook) {
 	that.MultiChildExitHook = func() error {
-		pid := os.Getpid()
 		defer os.Exit(0)
-		logger.Printf("[Child process]:%d, is exiting...", pid)
-		that.Status = GraceExiting
+		defer that.setStatus(GraceStopped, "exited")
+		that.Logger.Info("child process is exiting...", "phase", GraceDraining)
+		that.setStatus(GraceDraining, "exit hook")
 
 		exitFunc := func(ctx context.Context) sigChan {
 			c := make(sigChan)
@@ -87,19 +141,22 @@ func (that *Grace) SetExitHooksForMultiChild(beforeExit Hook, clearUp ...Hook) {
 				defer close(c)
 				// do sth. for clearups
 				if len(clearUp) > 0 {
-					if err := clearUp[0](); err != nil {
-						logger.Errorf("Child[Pid]: %d, 'clearUp' execution failed! err: %s", pid, err.Error())
+					if err := that.runHook("clearUp", clearUp[0]); err != nil {
+						that.Logger.Error("'clearUp' execution failed!", "phase", GraceDraining, "err", err)
 					}
 				}
 
-				err := beforeExit()
+				err := that.runHook("beforeExit", beforeExit)
 				if err != nil {
-					logger.Errorf("Child[Pid]: %d, 'beforeExit' execution failed! err: %s", pid, err.Error())
+					that.Logger.Error("'beforeExit' execution failed!", "phase", GraceDraining, "err", err)
 				}
+				// services are stopped after apps are drained
+				that.stopServices()
 			}()
 			return c
 		}
 		that.ExecuteWithTimeout("exit", exitFunc)
+		that.setStatus(GraceExiting, "exit hook finished")
 		return nil
 	}
 }
@@ -0,0 +1,205 @@
+package gkgrace
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gogf/gf/os/genv"
+	"github.com/moqsien/processes/logger"
+)
+
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelNone // disable all logs
+)
+
+func (that LogLevel) String() (r string) {
+	switch that {
+	case LevelDebug:
+		r = "debug"
+	case LevelInfo:
+		r = "info"
+	case LevelWarn:
+		r = "warn"
+	case LevelError:
+		r = "error"
+	default:
+		r = "none"
+	}
+	return
+}
+
+// ParseLogLevel parse "debug", "info", "warn", "error" or "none"
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "none", "off":
+		return LevelNone, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+}
+
+// Logger is the structured logger used by Grace and apps, kv are key/value pairs
+type Logger interface {
+	Debug(msg string, kv ...any)
+	Info(msg string, kv ...any)
+	Warn(msg string, kv ...any)
+	Error(msg string, kv ...any)
+	With(kv ...any) Logger
+}
+
+// LevelSetter is implemented by loggers which support changing level at runtime
+type LevelSetter interface {
+	SetLevel(level LogLevel)
+}
+
+// LogHandler writes a single record, implement it to route logs into your own pipeline
+type LogHandler interface {
+	Handle(level LogLevel, msg string, kv []any)
+}
+
+// LogHandlerFunc adapts a function to LogHandler
+type LogHandlerFunc func(level LogLevel, msg string, kv []any)
+
+func (that LogHandlerFunc) Handle(level LogLevel, msg string, kv []any) {
+	that(level, msg, kv)
+}
+
+type levelLogger struct {
+	handler LogHandler
+	level   *int32
+	fields  []any
+}
+
+// NewLogger create a Logger writing records of at least level to handler
+func NewLogger(handler LogHandler, level LogLevel) Logger {
+	l := int32(level)
+	return &levelLogger{handler: handler, level: &l}
+}
+
+func (that *levelLogger) log(level LogLevel, msg string, kv []any) {
+	if int32(level) < atomic.LoadInt32(that.level) {
+		return
+	}
+	fields := make([]any, 0, len(that.fields)+len(kv))
+	fields = append(fields, that.fields...)
+	fields = append(fields, kv...)
+	that.handler.Handle(level, msg, fields)
+}
+
+func (that *levelLogger) Debug(msg string, kv ...any) { that.log(LevelDebug, msg, kv) }
+func (that *levelLogger) Info(msg string, kv ...any)  { that.log(LevelInfo, msg, kv) }
+func (that *levelLogger) Warn(msg string, kv ...any)  { that.log(LevelWarn, msg, kv) }
+func (that *levelLogger) Error(msg string, kv ...any) { that.log(LevelError, msg, kv) }
+
+// With return a Logger sharing the same level, with extra fields
+func (that *levelLogger) With(kv ...any) Logger {
+	fields := make([]any, 0, len(that.fields)+len(kv))
+	fields = append(fields, that.fields...)
+	fields = append(fields, kv...)
+	return &levelLogger{handler: that.handler, level: that.level, fields: fields}
+}
+
+func (that *levelLogger) SetLevel(level LogLevel) {
+	atomic.StoreInt32(that.level, int32(level))
+}
+
+// copy return a copy of the logger with a level of its own, the current level is kept
+func (that *levelLogger) copy() *levelLogger {
+	l := atomic.LoadInt32(that.level)
+	return &levelLogger{handler: that.handler, level: &l, fields: that.fields}
+}
+
+// FormatFields format key/value pairs as "k1=v1 k2=v2"
+func FormatFields(kv []any) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if i+1 < len(kv) {
+			fmt.Fprintf(b, "%v=%v", kv[i], kv[i+1])
+		} else {
+			fmt.Fprintf(b, "%v=<missing>", kv[i])
+		}
+	}
+	return b.String()
+}
+
+// ProcessesHandler writes records through github.com/moqsien/processes/logger
+var ProcessesHandler = LogHandlerFunc(func(level LogLevel, msg string, kv []any) {
+	if len(kv) > 0 {
+		msg = msg + " " + FormatFields(kv)
+	}
+	switch level {
+	case LevelDebug:
+		logger.Debug(msg)
+	case LevelWarn:
+		logger.Warning(msg)
+	case LevelError:
+		logger.Error(msg)
+	default:
+		logger.Print(msg)
+	}
+})
+
+// SlogLogger is satisfied by *slog.Logger and other loggers of the same style
+type SlogLogger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// SlogHandler adapts a log/slog-style logger to LogHandler
+func SlogHandler(l SlogLogger) LogHandler {
+	return LogHandlerFunc(func(level LogLevel, msg string, kv []any) {
+		switch level {
+		case LevelDebug:
+			l.Debug(msg, kv...)
+		case LevelWarn:
+			l.Warn(msg, kv...)
+		case LevelError:
+			l.Error(msg, kv...)
+		default:
+			l.Info(msg, kv...)
+		}
+	})
+}
+
+// FromSlog create a Logger from a log/slog-style logger
+func FromSlog(l SlogLogger, level LogLevel) Logger {
+	return NewLogger(SlogHandler(l), level)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any)    {}
+func (nopLogger) Info(string, ...any)     {}
+func (nopLogger) Warn(string, ...any)     {}
+func (nopLogger) Error(string, ...any)    {}
+func (that nopLogger) With(...any) Logger { return that }
+
+// NopLogger return a Logger discarding everything
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+// CurrentLogLevel is inherited from parent process
+var CurrentLogLevel, _ = ParseLogLevel(genv.Get(GraceEnvLogLevel))
+
+// DefaultLogger is used when no Logger is set
+var DefaultLogger = NewLogger(ProcessesHandler, CurrentLogLevel)
@@ -0,0 +1,38 @@
+package gkgrace
+
+import "testing"
+
+func TestSetLoggerKeepsLevel(t *testing.T) {
+	var got []string
+	handler := LogHandlerFunc(func(level LogLevel, msg string, kv []any) {
+		got = append(got, msg)
+	})
+	l := NewLogger(handler, LevelDebug)
+	g := New()
+	g.SetLogLevel(LevelInfo)
+	g.SetLogger(l)
+
+	cases := []struct {
+		set    bool // call SetLogLevel(level) first
+		level  LogLevel
+		logger Logger
+		log    func(l Logger, msg string, kv ...any)
+		want   bool
+	}{
+		{false, 0, g.Logger, Logger.Debug, true}, // level of l is kept
+		{true, LevelError, g.Logger, Logger.Warn, false},
+		{false, 0, g.Logger, Logger.Error, true},
+		{false, 0, l, Logger.Debug, true}, // SetLogLevel does not change l
+		{true, LevelDebug, g.Logger, Logger.Debug, true},
+	}
+	for i, c := range cases {
+		if c.set {
+			g.SetLogLevel(c.level)
+		}
+		got = nil
+		c.log(c.logger, "test")
+		if logged := len(got) > 0; logged != c.want {
+			t.Errorf("case %d: logged = %v, want %v", i, logged, c.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package gkgrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/gogf/gf/os/genv"
+)
+
+// version of the handoff protocol between parent and child
+const ManifestVersion = 1
+
+// kinds of inherited files
+type FileKind string
+
+const (
+	KindListener   FileKind = "listener"
+	KindPacketConn FileKind = "packetconn"
+	KindFile       FileKind = "file"
+)
+
+// HandoffFile describes a file inherited from parent process
+type HandoffFile struct {
+	Name    string   `json:"name"`    // name in Container, Address.String() for sockets
+	Network string   `json:"network"` // network of socket, empty for plain files
+	Address string   `json:"address"` // local address of socket, empty for plain files
+	Kind    FileKind `json:"kind"`
+	Fd      int      `json:"fd"` // file descriptor in child process
+}
+
+// HandoffManifest describes everything a child process inherits from its parent
+type HandoffManifest struct {
+	Version    int           `json:"version"`
+	Generation int           `json:"generation"` // generation of the child process
+	ParentPid  int           `json:"parent_pid"`
+	Files      []HandoffFile `json:"files"`
+}
+
+func NewManifest(generation int) *HandoffManifest {
+	return &HandoffManifest{
+		Version:    ManifestVersion,
+		Generation: generation,
+		ParentPid:  os.Getpid(),
+	}
+}
+
+// Add append a file, fd is decided by the order of ExtraFiles
+func (that *HandoffManifest) Add(f HandoffFile) {
+	f.Fd = len(that.Files) + DefaultOffset
+	that.Files = append(that.Files, f)
+}
+
+// Lookup find an inherited file by name
+func (that *HandoffManifest) Lookup(name string) (f HandoffFile, found bool) {
+	if that == nil {
+		return
+	}
+	for _, v := range that.Files {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return
+}
+
+// Validate check the manifest before using any inherited file
+func (that *HandoffManifest) Validate(generation int) error {
+	if that.Version != ManifestVersion {
+		return fmt.Errorf("manifest: unsupported version %d, expected %d", that.Version, ManifestVersion)
+	}
+	if that.Generation != generation {
+		return fmt.Errorf("manifest: generation %d does not match current generation %d", that.Generation, generation)
+	}
+	names := make(map[string]bool)
+	fds := make(map[int]bool)
+	for i, f := range that.Files {
+		if f.Name == "" {
+			return fmt.Errorf("manifest: files[%d] has no name", i)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("manifest: files[%d] name %q duplicated", i, f.Name)
+		}
+		if f.Fd < DefaultOffset || fds[f.Fd] {
+			return fmt.Errorf("manifest: files[%d] has invalid fd %d", i, f.Fd)
+		}
+		switch f.Kind {
+		case KindListener, KindPacketConn:
+			if f.Network == "" {
+				return fmt.Errorf("manifest: files[%d] %q has no network", i, f.Name)
+			}
+		case KindFile:
+		default:
+			return fmt.Errorf("manifest: files[%d] %q has unknown kind %q", i, f.Name, f.Kind)
+		}
+		names[f.Name] = true
+		fds[f.Fd] = true
+	}
+	return nil
+}
+
+// Encode encode manifest into JSON
+func (that *HandoffManifest) Encode() ([]byte, error) {
+	return json.Marshal(that)
+}
+
+// LoadManifest read manifest passed by parent process, return nil if nothing is passed
+func LoadManifest() (*HandoffManifest, error) {
+	var content []byte
+	if s := genv.Get(GraceEnvManifest); s != "" {
+		content = []byte(s)
+	} else if s := genv.Get(GraceEnvManifestFd); s != "" {
+		fd, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("manifest: invalid fd %q", s)
+		}
+		f := os.NewFile(uintptr(fd), "manifest")
+		content, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("manifest: read fd %d failed: %s", fd, err.Error())
+		}
+	} else {
+		return nil, nil
+	}
+	m := &HandoffManifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("manifest: %s", err.Error())
+	}
+	return m, nil
+}
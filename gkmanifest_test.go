@@ -0,0 +1,90 @@
+package gkgrace
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestValidate(t *testing.T) {
+	listener := HandoffFile{Name: "tcp://127.0.0.1:8080", Network: "tcp", Address: "127.0.0.1:8080", Kind: KindListener}
+	file := HandoffFile{Name: "state", Kind: KindFile}
+	cases := []struct {
+		name   string
+		modify func(m *HandoffManifest)
+		err    string // empty if valid
+	}{
+		{"valid", func(m *HandoffManifest) {}, ""},
+		{"empty", func(m *HandoffManifest) { m.Files = nil }, ""},
+		{"version", func(m *HandoffManifest) { m.Version = ManifestVersion + 1 }, "unsupported version"},
+		{"generation", func(m *HandoffManifest) { m.Generation = 2 }, "does not match"},
+		{"no name", func(m *HandoffManifest) { m.Files[1].Name = "" }, "has no name"},
+		{"duplicated name", func(m *HandoffManifest) { m.Files[1].Name = m.Files[0].Name }, "duplicated"},
+		{"low fd", func(m *HandoffManifest) { m.Files[0].Fd = 2 }, "invalid fd"},
+		{"duplicated fd", func(m *HandoffManifest) { m.Files[1].Fd = m.Files[0].Fd }, "invalid fd"},
+		{"no network", func(m *HandoffManifest) { m.Files[0].Network = "" }, "has no network"},
+		{"unknown kind", func(m *HandoffManifest) { m.Files[1].Kind = "pipe" }, "unknown kind"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewManifest(1)
+			m.Add(listener)
+			m.Add(file)
+			c.modify(m)
+			err := m.Validate(1)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+				t.Fatalf("Validate() = %v, want error containing %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestManifestEncode(t *testing.T) {
+	m := NewManifest(3)
+	m.Add(HandoffFile{Name: "udp://127.0.0.1:53", Network: "udp", Address: "127.0.0.1:53", Kind: KindPacketConn})
+	m.Add(HandoffFile{Name: "state", Kind: KindFile})
+	if m.Files[0].Fd != DefaultOffset || m.Files[1].Fd != DefaultOffset+1 {
+		t.Fatalf("fds = %d, %d, want %d, %d", m.Files[0].Fd, m.Files[1].Fd, DefaultOffset, DefaultOffset+1)
+	}
+	content, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(GraceEnvManifest, string(content))
+	got, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("LoadManifest() = %+v, want %+v", got, m)
+	}
+	if err := got.Validate(3); err != nil {
+		t.Fatalf("Validate() of decoded manifest = %v", err)
+	}
+	if f, found := got.Lookup("state"); !found || f.Fd != DefaultOffset+1 {
+		t.Fatalf("Lookup(state) = %+v, %v", f, found)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(content, &fields)
+	for _, key := range []string{"version", "generation", "parent_pid", "files"} {
+		if _, found := fields[key]; !found {
+			t.Errorf("encoded manifest has no %q", key)
+		}
+	}
+}
+
+func TestLoadManifestNotPassed(t *testing.T) {
+	t.Setenv(GraceEnvManifest, "")
+	t.Setenv(GraceEnvManifestFd, "")
+	if m, err := LoadManifest(); m != nil || err != nil {
+		t.Fatalf("LoadManifest() = %v, %v, want nil, nil", m, err)
+	}
+	t.Setenv(GraceEnvManifest, "{")
+	if _, err := LoadManifest(); err == nil {
+		t.Fatal("LoadManifest() of broken JSON returned no error")
+	}
+}
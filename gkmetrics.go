@@ -0,0 +1,314 @@
+package gkgrace
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/container/gtype"
+)
+
+// MetricsCollector receives measurements of Grace, implement it to plug into your own registry
+type MetricsCollector interface {
+	SetGeneration(gen int)
+	ReloadAttempt()
+	ReloadSuccess(handoff time.Duration) // handoff latency
+	ReloadRollback()
+	DrainDuration(d time.Duration)
+	HookDuration(hook string, d time.Duration, err error)
+	ConnOpened(listener string)
+	ConnClosed(listener string)
+	WorkerRestart() // a worker of supervisor exited unexpectedly and is started again
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) SetGeneration(int)                         {}
+func (noopMetrics) ReloadAttempt()                            {}
+func (noopMetrics) ReloadSuccess(time.Duration)               {}
+func (noopMetrics) ReloadRollback()                           {}
+func (noopMetrics) DrainDuration(time.Duration)               {}
+func (noopMetrics) HookDuration(string, time.Duration, error) {}
+func (noopMetrics) ConnOpened(string)                         {}
+func (noopMetrics) ConnClosed(string)                         {}
+func (noopMetrics) WorkerRestart()                            {}
+
+// default buckets of histograms, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (that *histogram) observe(v float64) {
+	if that.counts == nil {
+		that.counts = make([]uint64, len(DefaultBuckets))
+	}
+	for i, b := range DefaultBuckets {
+		if v <= b {
+			that.counts[i]++
+		}
+	}
+	that.sum += v
+	that.count++
+}
+
+// Metrics is the builtin MetricsCollector which exports Prometheus text format
+type Metrics struct {
+	mu             sync.Mutex
+	generation     int
+	reloadAttempts uint64
+	reloadSuccess  uint64
+	reloadRollback uint64
+	workerRestarts uint64
+	handoff        histogram
+	drain          histogram
+	hooks          map[string]*histogram
+	hookErrors     map[string]uint64
+	conns          map[string]int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		hooks:      make(map[string]*histogram),
+		hookErrors: make(map[string]uint64),
+		conns:      make(map[string]int64),
+	}
+}
+
+func (that *Metrics) SetGeneration(gen int) {
+	that.mu.Lock()
+	that.generation = gen
+	that.mu.Unlock()
+}
+
+func (that *Metrics) ReloadAttempt() {
+	that.mu.Lock()
+	that.reloadAttempts++
+	that.mu.Unlock()
+}
+
+func (that *Metrics) ReloadSuccess(handoff time.Duration) {
+	that.mu.Lock()
+	that.reloadSuccess++
+	that.handoff.observe(handoff.Seconds())
+	that.mu.Unlock()
+}
+
+func (that *Metrics) ReloadRollback() {
+	that.mu.Lock()
+	that.reloadRollback++
+	that.mu.Unlock()
+}
+
+func (that *Metrics) DrainDuration(d time.Duration) {
+	that.mu.Lock()
+	that.drain.observe(d.Seconds())
+	that.mu.Unlock()
+}
+
+func (that *Metrics) HookDuration(hook string, d time.Duration, err error) {
+	that.mu.Lock()
+	h, ok := that.hooks[hook]
+	if !ok {
+		h = &histogram{}
+		that.hooks[hook] = h
+	}
+	h.observe(d.Seconds())
+	if err != nil {
+		that.hookErrors[hook]++
+	}
+	that.mu.Unlock()
+}
+
+func (that *Metrics) ConnOpened(listener string) {
+	that.mu.Lock()
+	that.conns[listener]++
+	that.mu.Unlock()
+}
+
+func (that *Metrics) ConnClosed(listener string) {
+	that.mu.Lock()
+	that.conns[listener]--
+	that.mu.Unlock()
+}
+
+func (that *Metrics) WorkerRestart() {
+	that.mu.Lock()
+	that.workerRestarts++
+	that.mu.Unlock()
+}
+
+// WriteTo write all metrics in Prometheus text format
+func (that *Metrics) WriteTo(w io.Writer) (int64, error) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	b := &strings.Builder{}
+	writeMetric(b, "gkgrace_generation", "gauge", "Generation of current process.", float64(that.generation))
+	writeMetric(b, "gkgrace_reload_attempts_total", "counter", "Number of reload attempts.", float64(that.reloadAttempts))
+	writeMetric(b, "gkgrace_reload_success_total", "counter", "Number of reloads handed off to a child.", float64(that.reloadSuccess))
+	writeMetric(b, "gkgrace_reload_rollbacks_total", "counter", "Number of failed reloads.", float64(that.reloadRollback))
+	writeMetric(b, "gkgrace_worker_restarts_total", "counter", "Number of worker restarts.", float64(that.workerRestarts))
+	writeHistogram(b, "gkgrace_handoff_latency_seconds", "Time from reload signal to handoff.", map[string]*histogram{"": &that.handoff})
+	writeHistogram(b, "gkgrace_drain_duration_seconds", "Time spent on draining.", map[string]*histogram{"": &that.drain})
+	writeHistogram(b, "gkgrace_hook_duration_seconds", "Time spent on hooks.", that.hooks)
+
+	fmt.Fprintf(b, "# HELP gkgrace_hook_errors_total Number of failed hooks.\n# TYPE gkgrace_hook_errors_total counter\n")
+	for _, k := range sortedKeys(that.hookErrors) {
+		fmt.Fprintf(b, "gkgrace_hook_errors_total{hook=%q} %d\n", k, that.hookErrors[k])
+	}
+	fmt.Fprintf(b, "# HELP gkgrace_active_connections Number of active connections per listener.\n# TYPE gkgrace_active_connections gauge\n")
+	for _, k := range sortedKeys(that.conns) {
+		fmt.Fprintf(b, "gkgrace_active_connections{listener=%q} %d\n", k, that.conns[k])
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serve metrics in Prometheus text format
+func (that *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	that.WriteTo(w)
+}
+
+func writeMetric(b *strings.Builder, name, typ, help string, v float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+}
+
+func writeHistogram(b *strings.Builder, name, help string, hs map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, k := range sortedKeys(hs) {
+		h := hs[k]
+		label := ""
+		if k != "" {
+			label = fmt.Sprintf("hook=%q,", k)
+		}
+		for i, bucket := range DefaultBuckets {
+			var c uint64
+			if h.counts != nil {
+				c = h.counts[i]
+			}
+			fmt.Fprintf(b, "%s_bucket{%sle=\"%g\"} %d\n", name, label, bucket, c)
+		}
+		fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, label, h.count)
+		label = strings.TrimSuffix(label, ",")
+		if label != "" {
+			label = "{" + label + "}"
+		}
+		fmt.Fprintf(b, "%s_sum%s %g\n%s_count%s %d\n", name, label, h.sum, name, label, h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// CauseReloaded is the cause of Reloading -> Running when a reload finished in place, like rolling reloads of
+// multi-process mode, other transitions from Reloading to Running are rollbacks
+const CauseReloaded = "reloaded"
+
+// observeTransition turn status transitions into reload and drain metrics
+func (that *Grace) observeTransition(t Transition) {
+	switch {
+	case t.To == GraceReloading:
+		that.Metrics.ReloadAttempt()
+	case t.From == GraceReloading && (t.To == GraceHandedOff || t.To == GraceRunning && t.Cause == CauseReloaded):
+		that.Metrics.ReloadSuccess(t.Time.Sub(that.Status.since(GraceReloading)))
+	case t.From == GraceReloading && t.To == GraceRunning:
+		that.Metrics.ReloadRollback()
+	case t.From == GraceDraining:
+		that.Metrics.DrainDuration(t.Time.Sub(that.Status.since(GraceDraining)))
+	}
+}
+
+// SetMetrics enable metrics with collector m
+func (that *Grace) SetMetrics(m MetricsCollector) {
+	that.Metrics = m
+	that.metrics.Set(collector{m})
+	m.SetGeneration(that.Generation)
+	if that.cancelMetrics != nil {
+		that.cancelMetrics()
+	}
+	that.cancelMetrics = that.Subscribe(that.observeTransition)
+}
+
+// ServeMetrics serve builtin metrics on addr, the listener is inherited like any other,
+// only single-process mode is supported, serve MetricsCollector by an app in other modes
+func (that *Grace) ServeMetrics(addr *Address) error {
+	if that.IsMulti {
+		return fmt.Errorf("ServeMetrics: multi-process mode is not supported!")
+	}
+	if that.IsSupervisor || that.Supervised {
+		return fmt.Errorf("ServeMetrics: supervisor mode is not supported!")
+	}
+	m, ok := that.Metrics.(*Metrics)
+	if !ok {
+		m = NewMetrics()
+		that.SetMetrics(m)
+	}
+	ln := that.GetListener(&innerAddr{addr: addr})
+	if ln == nil {
+		return fmt.Errorf("Cannot get a listener for metrics! ")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// collector keeps the type stored in Grace.metrics the same
+type collector struct {
+	MetricsCollector
+}
+
+// countingListener report opened and closed connections to the collector set when they are accepted
+type countingListener struct {
+	net.Listener
+	name    string
+	metrics *gtype.Interface
+}
+
+func (that *countingListener) Accept() (net.Conn, error) {
+	c, err := that.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	m := that.metrics.Val().(collector).MetricsCollector
+	if _, ok := m.(noopMetrics); ok {
+		return c, nil
+	}
+	m.ConnOpened(that.name)
+	return &countingConn{Conn: c, closed: func() { m.ConnClosed(that.name) }}, nil
+}
+
+// Unwrap return the original listener
+func (that *countingListener) Unwrap() net.Listener {
+	return that.Listener
+}
+
+type countingConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (that *countingConn) Close() error {
+	that.once.Do(that.closed)
+	return that.Conn.Close()
+}
+
+// Unwrap return the original connection
+func (that *countingConn) Unwrap() net.Conn {
+	return that.Conn
+}
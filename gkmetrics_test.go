@@ -0,0 +1,62 @@
+package gkgrace
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMetricsMultiReload(t *testing.T) {
+	g := New()
+	g.IsChild = false
+	g.SetToMulti()
+	m := NewMetrics()
+	g.SetMetrics(m)
+	fail := false
+	g.SetReloadHooksForMulti(func() error {
+		if fail {
+			return fmt.Errorf("workers did not start")
+		}
+		return nil
+	})
+	g.setStatus(GraceStarting, "test")
+	g.setStatus(GraceRunning, "test")
+	go g.WaitForMulti()
+
+	cases := []struct {
+		fail      bool
+		attempts  int
+		success   int
+		rollbacks int
+	}{
+		{false, 1, 1, 0},
+		{false, 2, 2, 0},
+		{true, 3, 2, 1},
+	}
+	for i, c := range cases {
+		reloaded := make(chan Transition, 1)
+		cancel := g.OnTransition(GraceRunning, func(t Transition) { reloaded <- t })
+		fail = c.fail
+		g.Signal <- syscall.SIGUSR2
+		select {
+		case <-reloaded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("reload %d did not finish", i)
+		}
+		cancel()
+		b := &strings.Builder{}
+		m.WriteTo(b)
+		for name, want := range map[string]int{
+			"gkgrace_reload_attempts_total":         c.attempts,
+			"gkgrace_reload_success_total":          c.success,
+			"gkgrace_reload_rollbacks_total":        c.rollbacks,
+			"gkgrace_handoff_latency_seconds_count": c.success,
+		} {
+			if line := fmt.Sprintf("\n%s %d\n", name, want); !strings.Contains(b.String(), line) {
+				t.Errorf("reload %d: %s is not %d", i, name, want)
+			}
+		}
+	}
+}
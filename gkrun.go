@@ -0,0 +1,111 @@
+package gkgrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// IRunnable is an app started and stopped by Run, Execute returns once it is listening, like apps in apps/x*
+type IRunnable interface {
+	IAddress
+	IService
+}
+
+// Run register and start apps with a new Grace, see RunApps
+func Run(ctx context.Context, apps ...IRunnable) error {
+	return New().RunApps(ctx, apps...)
+}
+
+// RunApps register and start apps, then wait for signals like Wait, it returns when the process stops instead of exiting.
+// Apps are started as services, the process is ready and a reloaded parent is told to exit only after all of them are listening.
+// If an app fails or ctx is done, all apps are stopped gracefully in reverse order,
+// errors of apps and services are returned with their names, a reloaded parent returns nil.
+// Multi-process mode is not supported, its processes exit in MultiExitingHook and MultiChildExitHook.
+func (that *Grace) RunApps(ctx context.Context, apps ...IRunnable) error {
+	if that.IsMulti {
+		return fmt.Errorf("RunApps: multi-process mode is not supported, use Register and Wait instead!")
+	}
+	var (
+		mu   sync.Mutex
+		errs []string
+		once sync.Once
+		code int
+	)
+	for _, app := range apps {
+		if err := that.Register(app); err != nil {
+			return fmt.Errorf("%s: %s", app.Name(), err.Error())
+		}
+		if that.IsSupervisor {
+			continue // workers serve listeners of supervisor
+		}
+		// started by Wait, stopped in reverse order after draining
+		if err := that.AddService(app); err != nil {
+			return err
+		}
+	}
+	that.SetExitHooksForSingle(func() error { return nil })
+
+	done := make(chan struct{})
+	that.onExit = func(c int) {
+		once.Do(func() {
+			code = c
+			close(done)
+		})
+	}
+	stop := func() {
+		select {
+		case that.Signal <- syscall.SIGTERM:
+		case <-done:
+		}
+	}
+	that.onAppError = func(name string, err error) {
+		if !that.Status.Is(GraceStarting, GraceRunning) {
+			return // stopped by Exit
+		}
+		that.Logger.Error("app failed, stopping all apps", "app", name, "err", err)
+		mu.Lock()
+		errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+		mu.Unlock()
+		stop()
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			that.Logger.Info("context is done, stopping all apps", "err", ctx.Err())
+			stop()
+		case <-done:
+		}
+	}()
+	go func() {
+		that.Wait()
+		that.onExit(0)
+	}()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, c := range that.Services() {
+		if c.State == ServiceFailed {
+			errs = append(errs, fmt.Sprintf("%s: %s", c.App.Name(), c.Err.Error()))
+		}
+	}
+	if code != 0 {
+		errs = append(errs, fmt.Sprintf("exited with code %d", code))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ReportAppError report that an app stopped serving with err after it was started, RunApps stops all apps then
+func (that *Grace) ReportAppError(name string, err error) {
+	if that.onAppError != nil {
+		that.onAppError(name, err)
+		return
+	}
+	that.Logger.Error("app stopped", "app", name, "err", err)
+}
@@ -0,0 +1,167 @@
+package gkgrace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gogf/gf/os/gtime"
+)
+
+// states of services
+const (
+	ServiceIdle     = 0 // not started
+	ServiceStarting = 1
+	ServiceRunning  = 2
+	ServiceStopping = 3
+	ServiceStopped  = 4
+	ServiceFailed   = 5
+)
+
+// IService is a service without listener, such as queue consumers, schedulers and cache warmers.
+// Execute starts the service and returns, work is done in its own goroutines; Exit stops it.
+type IService interface {
+	Name() string
+	Execute() error
+	Exit() error
+}
+
+type AppContainer struct {
+	App       IService
+	DependsOn []string    // names of services started before it
+	StartTime *gtime.Time // app start time
+	StopTime  *gtime.Time // app stop time
+	State     int         // status
+	Err       error       // error of Execute or Exit
+}
+
+// AddService add a service started by Wait after the services it depends on, the process is ready after they are started,
+// services are stopped in reverse order after apps are drained, they run in child processes in multi-process mode
+func (that *Grace) AddService(s IService, dependsOn ...string) error {
+	that.servicesMu.Lock()
+	defer that.servicesMu.Unlock()
+	for _, c := range that.services {
+		if c.App.Name() == s.Name() {
+			return fmt.Errorf("Service: %s is duplicated!", s.Name())
+		}
+	}
+	that.services = append(that.services, &AppContainer{App: s, DependsOn: dependsOn})
+	return nil
+}
+
+// Services return a snapshot of services in order of adding
+func (that *Grace) Services() []AppContainer {
+	that.servicesMu.Lock()
+	defer that.servicesMu.Unlock()
+	result := make([]AppContainer, 0, len(that.services))
+	for _, c := range that.services {
+		result = append(result, *c)
+	}
+	return result
+}
+
+// sortServices return services ordered by dependencies, order of adding is kept otherwise
+func (that *Grace) sortServices() ([]*AppContainer, error) {
+	byName := make(map[string]*AppContainer, len(that.services))
+	for _, c := range that.services {
+		byName[c.App.Name()] = c
+	}
+	for _, c := range that.services {
+		for _, dep := range c.DependsOn {
+			if _, found := byName[dep]; !found {
+				return nil, fmt.Errorf("Service: %s depends on unknown service %s!", c.App.Name(), dep)
+			}
+		}
+	}
+	var (
+		sorted []*AppContainer
+		added  = make(map[string]bool, len(that.services))
+	)
+	for len(sorted) < len(that.services) {
+		progress := false
+		for _, c := range that.services {
+			if added[c.App.Name()] {
+				continue
+			}
+			ready := true
+			for _, dep := range c.DependsOn {
+				ready = ready && added[dep]
+			}
+			if ready {
+				sorted = append(sorted, c)
+				added[c.App.Name()] = true
+				progress = true
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, c := range that.services {
+				if !added[c.App.Name()] {
+					cycle = append(cycle, c.App.Name())
+				}
+			}
+			return nil, fmt.Errorf("Service: dependency cycle among %s!", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+// startServices start services in dependency order, started ones are stopped if any fails
+func (that *Grace) startServices() error {
+	that.servicesMu.Lock()
+	sorted, err := that.sortServices()
+	that.servicesMu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, c := range sorted {
+		name := c.App.Name()
+		that.setServiceState(c, ServiceStarting, nil)
+		if err := that.runHook("execute:"+name, c.App.Execute); err != nil {
+			that.setServiceState(c, ServiceFailed, err)
+			that.Logger.Error("service failed to start", "service", name, "err", err)
+			that.stopServices()
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+		that.setServiceState(c, ServiceRunning, nil)
+		that.Logger.Info("service started", "service", name)
+		that.servicesMu.Lock()
+		that.started = append(that.started, c)
+		that.servicesMu.Unlock()
+	}
+	return nil
+}
+
+// stopServices call Exit of running services in reverse order of starting
+func (that *Grace) stopServices() {
+	that.servicesMu.Lock()
+	started := that.started
+	that.started = nil
+	that.servicesMu.Unlock()
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		name := c.App.Name()
+		that.setServiceState(c, ServiceStopping, nil)
+		if err := that.runHook("exit:"+name, c.App.Exit); err != nil {
+			that.setServiceState(c, ServiceFailed, err)
+			that.Logger.Error("service failed to stop", "service", name, "err", err)
+			continue
+		}
+		that.setServiceState(c, ServiceStopped, nil)
+		that.Logger.Info("service stopped", "service", name)
+	}
+}
+
+func (that *Grace) setServiceState(c *AppContainer, state int, err error) {
+	that.servicesMu.Lock()
+	defer that.servicesMu.Unlock()
+	c.State = state
+	if err != nil {
+		c.Err = err
+	}
+	switch state {
+	case ServiceStarting:
+		c.StartTime, c.StopTime, c.Err = gtime.Now(), nil, nil
+	case ServiceStopped:
+		c.StopTime = gtime.Now()
+	}
+}
@@ -0,0 +1,185 @@
+package gkgrace
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Transition describes a change of GraceStatus
+type Transition struct {
+	From  GraceStatus
+	To    GraceStatus
+	Cause string    // why the transition happened
+	Time  time.Time // when the transition happened
+}
+
+func (that Transition) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", that.From, that.To, that.Cause)
+}
+
+// TransitionFunc is called after every accepted transition
+type TransitionFunc func(t Transition)
+
+// allowed transitions of the lifecycle state machine
+var validTransitions = map[GraceStatus][]GraceStatus{
+	GraceUnKnown:   {GraceStarting, GraceExiting},
+	GraceStarting:  {GraceRunning, GraceDraining, GraceExiting, GraceStopped}, // stopped before ready
+	GraceRunning:   {GraceReloading, GraceLameDuck, GraceDraining, GraceExiting},
+	GraceLameDuck:  {GraceDraining, GraceExiting},
+	GraceReloading: {GraceRunning, GraceHandedOff, GraceDraining, GraceExiting},
+	GraceHandedOff: {GraceDraining, GraceExiting, GraceRunning}, // a supervisor keeps running
+	GraceDraining:  {GraceExiting, GraceStopped},
+	GraceExiting:   {GraceStopped},
+	GraceStopped:   {},
+}
+
+type subscriber struct {
+	id int
+	fn TransitionFunc
+}
+
+// StateMachine is the concurrency-safe lifecycle state of a process
+type StateMachine struct {
+	mu          sync.RWMutex
+	current     GraceStatus
+	last        Transition
+	entered     map[GraceStatus]time.Time
+	nextId      int
+	subscribers []subscriber
+}
+
+func NewStateMachine() *StateMachine {
+	now := time.Now()
+	return &StateMachine{
+		current: GraceUnKnown,
+		last:    Transition{From: GraceUnKnown, To: GraceUnKnown, Time: now},
+		entered: map[GraceStatus]time.Time{GraceUnKnown: now},
+	}
+}
+
+// Get return the current status
+func (that *StateMachine) Get() GraceStatus {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	return that.current
+}
+
+// Is return true if current status is one of s
+func (that *StateMachine) Is(s ...GraceStatus) bool {
+	cur := that.Get()
+	for _, v := range s {
+		if v == cur {
+			return true
+		}
+	}
+	return false
+}
+
+// Last return the last accepted transition
+func (that *StateMachine) Last() Transition {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	return that.last
+}
+
+// CanTransit check if current status can be changed to "to"
+func (that *StateMachine) CanTransit(to GraceStatus) bool {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	return canTransit(that.current, to)
+}
+
+func canTransit(from, to GraceStatus) bool {
+	for _, v := range validTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transit change current status to "to", subscribers are called synchronously
+func (that *StateMachine) Transit(to GraceStatus, cause string) error {
+	that.mu.Lock()
+	if !canTransit(that.current, to) {
+		from := that.current
+		that.mu.Unlock()
+		return fmt.Errorf("invalid transition: %s -> %s (%s)", from, to, cause)
+	}
+	t := that.apply(to, cause)
+	that.mu.Unlock()
+	that.publish(t)
+	return nil
+}
+
+// TransitFrom change status to "to" only if current status is "from"
+func (that *StateMachine) TransitFrom(from, to GraceStatus, cause string) bool {
+	that.mu.Lock()
+	if that.current != from || !canTransit(from, to) {
+		that.mu.Unlock()
+		return false
+	}
+	t := that.apply(to, cause)
+	that.mu.Unlock()
+	that.publish(t)
+	return true
+}
+
+func (that *StateMachine) apply(to GraceStatus, cause string) Transition {
+	t := Transition{
+		From:  that.current,
+		To:    to,
+		Cause: cause,
+		Time:  time.Now(),
+	}
+	that.current = to
+	that.last = t
+	that.entered[to] = t.Time
+	return t
+}
+
+// since return the time when status s was entered for the last time
+func (that *StateMachine) since(s GraceStatus) time.Time {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	return that.entered[s]
+}
+
+func (that *StateMachine) publish(t Transition) {
+	that.mu.RLock()
+	subs := make([]subscriber, len(that.subscribers))
+	copy(subs, that.subscribers)
+	that.mu.RUnlock()
+	for _, s := range subs {
+		s.fn(t)
+	}
+}
+
+// Subscribe call fn on every transition, use the returned function to unsubscribe
+func (that *StateMachine) Subscribe(fn TransitionFunc) (cancel func()) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.nextId++
+	id := that.nextId
+	that.subscribers = append(that.subscribers, subscriber{id: id, fn: fn})
+	return func() {
+		that.mu.Lock()
+		defer that.mu.Unlock()
+		for i, s := range that.subscribers {
+			if s.id == id {
+				that.subscribers = append(that.subscribers[:i], that.subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// OnTransition call fn only when status is changed to "to"
+func (that *StateMachine) OnTransition(to GraceStatus, fn TransitionFunc) (cancel func()) {
+	return that.Subscribe(func(t Transition) {
+		if t.To == to {
+			fn(t)
+		}
+	})
+}
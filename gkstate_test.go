@@ -0,0 +1,91 @@
+package gkgrace
+
+import "testing"
+
+func TestValidTransitions(t *testing.T) {
+	all := []GraceStatus{GraceUnKnown, GraceStarting, GraceRunning, GraceLameDuck, GraceReloading,
+		GraceHandedOff, GraceDraining, GraceExiting, GraceStopped}
+	cases := []struct {
+		from GraceStatus
+		to   []GraceStatus
+	}{
+		{GraceUnKnown, []GraceStatus{GraceStarting, GraceExiting}},
+		{GraceStarting, []GraceStatus{GraceRunning, GraceDraining, GraceExiting, GraceStopped}},
+		{GraceRunning, []GraceStatus{GraceReloading, GraceLameDuck, GraceDraining, GraceExiting}},
+		{GraceLameDuck, []GraceStatus{GraceDraining, GraceExiting}},
+		{GraceReloading, []GraceStatus{GraceRunning, GraceHandedOff, GraceDraining, GraceExiting}},
+		{GraceHandedOff, []GraceStatus{GraceDraining, GraceExiting, GraceRunning}},
+		{GraceDraining, []GraceStatus{GraceExiting, GraceStopped}},
+		{GraceExiting, []GraceStatus{GraceStopped}},
+		{GraceStopped, nil},
+	}
+	for _, c := range cases {
+		allowed := make(map[GraceStatus]bool)
+		for _, s := range c.to {
+			allowed[s] = true
+		}
+		for _, to := range all {
+			if got := canTransit(c.from, to); got != allowed[to] {
+				t.Errorf("canTransit(%s, %s) = %v, want %v", c.from, to, got, allowed[to])
+			}
+		}
+	}
+}
+
+func TestStateMachineTransit(t *testing.T) {
+	s := NewStateMachine()
+	var seen []Transition
+	s.Subscribe(func(t Transition) { seen = append(seen, t) })
+	steps := []struct {
+		to GraceStatus
+		ok bool
+	}{
+		{GraceRunning, false},
+		{GraceStarting, true},
+		{GraceRunning, true},
+		{GraceStarting, false},
+		{GraceReloading, true},
+		{GraceHandedOff, true},
+		{GraceDraining, true},
+		{GraceRunning, false},
+		{GraceExiting, true},
+		{GraceStopped, true},
+		{GraceExiting, false},
+	}
+	accepted := 0
+	for i, step := range steps {
+		from := s.Get()
+		err := s.Transit(step.to, "test")
+		if (err == nil) != step.ok {
+			t.Fatalf("step %d: Transit(%s -> %s) err = %v, want ok %v", i, from, step.to, err, step.ok)
+		}
+		if step.ok {
+			accepted++
+			if s.Get() != step.to {
+				t.Fatalf("step %d: current = %s, want %s", i, s.Get(), step.to)
+			}
+			if last := s.Last(); last.From != from || last.To != step.to {
+				t.Fatalf("step %d: last = %s, want %s -> %s", i, last, from, step.to)
+			}
+		} else if s.Get() != from {
+			t.Fatalf("step %d: rejected transition changed status to %s", i, s.Get())
+		}
+	}
+	if len(seen) != accepted {
+		t.Fatalf("subscriber called %d times, want %d", len(seen), accepted)
+	}
+}
+
+func TestStateMachineTransitFrom(t *testing.T) {
+	s := NewStateMachine()
+	s.Transit(GraceStarting, "test")
+	if s.TransitFrom(GraceRunning, GraceDraining, "test") {
+		t.Fatal("TransitFrom accepted a wrong current status")
+	}
+	if !s.TransitFrom(GraceStarting, GraceRunning, "test") {
+		t.Fatal("TransitFrom rejected a valid transition")
+	}
+	if s.TransitFrom(GraceRunning, GraceStarting, "test") {
+		t.Fatal("TransitFrom accepted an invalid transition")
+	}
+}
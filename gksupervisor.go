@@ -0,0 +1,365 @@
+package gkgrace
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// name of the inherited socket between supervisor and worker
+const SupervisorFileName = "gkgrace.supervisor"
+
+// minimum interval between restarts of a crashed worker
+const DefaultRestartDelay = time.Second
+
+// IsSupervised is true in worker processes started by a supervisor
+var IsSupervised = os.Getenv(GraceEnvSupervised) == "true"
+
+// worker is a server process started by supervisor
+type worker struct {
+	cmd        *exec.Cmd
+	conn       *net.UnixConn // "READY" and "KEYS" from worker, "KEYS" and "HANDOFF" to worker
+	generation int
+}
+
+func (that *worker) pid() int {
+	return that.cmd.Process.Pid
+}
+
+// SetToSupervisor enable supervisor mode, call it before Register
+// the process started by user owns listeners, reaps zombies and forwards signals,
+// while workers serve requests and are replaced on reload, the pid is kept for PID 1 containers
+func (that *Grace) SetToSupervisor() {
+	if that.Supervised {
+		return // already a worker
+	}
+	that.IsSupervisor = true
+}
+
+// prepareSupervision pass a socketpair to worker for readiness, ticket keys and handoff
+func (that *Grace) prepareSupervision(h *handoff) error {
+	if !that.IsSupervisor {
+		return nil
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	local := os.NewFile(uintptr(fds[0]), SupervisorFileName)
+	remote := os.NewFile(uintptr(fds[1]), SupervisorFileName)
+	// ticket keys of the serving worker come first, so the new one resumes its sessions
+	if _, err := local.Write(that.ticketKeysLine()); err != nil {
+		local.Close()
+		remote.Close()
+		return err
+	}
+	h.addFile(SupervisorFileName, remote)
+	h.started = append(h.started, func() {
+		defer local.Close()
+		if c, err := net.FileConn(local); err == nil {
+			that.workerConn = c.(*net.UnixConn)
+		}
+	})
+	h.aborted = append(h.aborted, func() {
+		local.Close()
+	})
+	return nil
+}
+
+// startWorker start the next generation of worker
+func (that *Grace) startWorker(ready chan<- *worker) (*worker, error) {
+	that.workerConn = nil
+	cmd, h, err := that.newChildCmd()
+	defer that.closeFiles(h.files)
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		h.done(false)
+		return nil, err
+	}
+	h.done(true)
+	w := &worker{cmd: cmd, conn: that.workerConn, generation: h.manifest.Generation}
+	that.Logger.Info("worker started", "worker", w.pid(), "worker_generation", w.generation)
+	if w.conn != nil {
+		go func() {
+			r := bufio.NewReader(w.conn)
+			for {
+				line, err := r.ReadString('\n')
+				switch line = strings.TrimSpace(line); {
+				case line == "READY":
+					ready <- w
+				case strings.HasPrefix(line, "KEYS"):
+					data, err := parseTicketKeysLine(line)
+					if err == nil {
+						err = that.tickets.set(data)
+					}
+					if err != nil {
+						that.Logger.Error("invalid session ticket keys from worker", "worker", w.pid(), "err", err)
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	return w, nil
+}
+
+// WaitForSupervisor run supervisor until all workers exit
+func (that *Grace) WaitForSupervisor() {
+	// buffered, so that SIGCHLD is not dropped while busy
+	that.Signal = make(chan os.Signal, 16)
+	signal.Notify(
+		that.Signal,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+		syscall.SIGTERM,
+		syscall.SIGABRT,
+		syscall.SIGUSR2,
+		syscall.SIGUSR1,
+		syscall.SIGCHLD,
+	)
+	that.notifyReloadSignal()
+	var (
+		current, pending *worker
+		restart          <-chan time.Time
+		lastStart        time.Time
+		retired          = make(map[int]*worker)
+		ready            = make(chan *worker, 1)
+		stopped          <-chan time.Time
+		stopping         bool // kept even if a status transition fails
+	)
+	start := func() *worker {
+		lastStart = time.Now()
+		w, err := that.startWorker(ready)
+		if err != nil {
+			that.Logger.Error("Start worker failed!", "err", err)
+		}
+		return w
+	}
+	exiting := func() bool {
+		return stopping || that.Status.Is(GraceDraining, GraceExiting, GraceStopped)
+	}
+	exited := func(cause string, code int) {
+		if !that.Status.Is(GraceExiting) {
+			that.setStatus(GraceExiting, cause)
+		}
+		that.setStatus(GraceStopped, "exited")
+		that.exit(code)
+	}
+	alive := func() (r []*worker) {
+		for _, w := range []*worker{current, pending} {
+			if w != nil {
+				r = append(r, w)
+			}
+		}
+		for _, w := range retired {
+			r = append(r, w)
+		}
+		return
+	}
+	if current = start(); current == nil {
+		exited("no worker", 1)
+	}
+	for {
+		select {
+		case w := <-ready:
+			if w == pending {
+				// the new worker has taken over, retire the old one
+				that.Status.TransitFrom(GraceReloading, GraceHandedOff, fmt.Sprintf("worker %d ready", w.pid()))
+				if current != nil {
+					that.retire(current)
+					retired[current.pid()] = current
+				}
+				current, pending = w, nil
+			}
+			if w == current && !exiting() {
+				that.Generation = w.generation
+				that.Metrics.SetGeneration(w.generation)
+				that.Status.TransitFrom(GraceStarting, GraceRunning, "worker ready")
+				that.Status.TransitFrom(GraceHandedOff, GraceRunning, "worker ready")
+			}
+		case <-restart:
+			restart = nil
+			if current == nil && !exiting() {
+				that.Metrics.WorkerRestart()
+				current = start()
+			}
+		case <-stopped:
+			that.Logger.Error("workers did not exit in time, killed", "phase", GraceDraining)
+			for _, w := range alive() {
+				w.cmd.Process.Kill()
+			}
+			stopped = nil
+		case sig := <-that.Signal:
+			switch sig = that.mapSignal(sig); sig {
+			case syscall.SIGCHLD:
+				for _, pid := range reap() {
+					switch {
+					case current != nil && pid == current.pid():
+						current = nil
+						if !exiting() {
+							// crashed, restart after a while to avoid busy loops
+							delay := DefaultRestartDelay - time.Since(lastStart)
+							that.Logger.Error("Worker exited unexpectedly!", "worker", pid)
+							restart = time.After(delay)
+						}
+					case pending != nil && pid == pending.pid():
+						pending = nil
+						that.Status.TransitFrom(GraceReloading, GraceRunning, "worker exited before handoff")
+						that.Logger.Error("Worker exited before handoff!", "phase", GraceReloading, "worker", pid)
+					default:
+						delete(retired, pid)
+					}
+				}
+				if exiting() && len(alive()) == 0 {
+					that.Logger.Info("supervisor exited.", "phase", GraceStopped)
+					exited("workers exited", 0)
+				}
+			case syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGABRT:
+				if !stopping {
+					stopping, restart = true, nil
+					if that.Status.Is(GraceStarting) {
+						// no worker is ready, nothing to drain
+						that.setStatus(GraceExiting, "signal: "+sig.String())
+					} else {
+						that.setStatus(GraceDraining, "signal: "+sig.String())
+					}
+					// workers keep serving during lame-duck before draining
+					stopped = time.After(that.LameDuck + that.MaxWaitTime + time.Second)
+				}
+				for _, w := range alive() {
+					w.cmd.Process.Signal(sig)
+				}
+				if len(alive()) == 0 {
+					exited("no worker", 0)
+				}
+			case syscall.SIGUSR2:
+				if pending != nil {
+					that.Logger.Warn("reload is in progress, ignored", "worker", pending.pid())
+					continue
+				}
+				if err := that.Status.Transit(GraceReloading, "signal: "+sig.String()); err != nil {
+					that.Logger.Error("cannot reload", "err", err)
+					continue
+				}
+				if pending = start(); pending == nil {
+					that.setStatus(GraceRunning, "reload failed")
+				}
+			case syscall.SIGHUP:
+				// only subscribed with a config file
+				key, err := that.reloadConfig()
+				if err != nil {
+					continue // the worker would fail to load it too
+				}
+				if key != "" {
+					// a new worker loads the new config
+					that.reloadForConfig(key)
+					continue
+				}
+				// the worker reloads config in place too
+				if current != nil {
+					current.cmd.Process.Signal(sig)
+				}
+			default:
+				// forward anything else to the serving worker
+				if current != nil {
+					current.cmd.Process.Signal(sig)
+				}
+			}
+		}
+	}
+}
+
+// retire ask a replaced worker to drain as a handed off process
+func (that *Grace) retire(w *worker) {
+	if w.conn != nil {
+		if _, err := w.conn.Write([]byte("HANDOFF\n")); err == nil {
+			w.conn.Close()
+			return
+		}
+		w.conn.Close()
+	}
+	w.cmd.Process.Signal(syscall.SIGQUIT)
+}
+
+// reap collect every exited child, orphans adopted by PID 1 included
+func reap() (pids []int) {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return
+		}
+		pids = append(pids, pid)
+	}
+}
+
+// connectSupervisor connect to supervisor in worker process and import ticket keys of the previous worker
+func (that *Grace) connectSupervisor() error {
+	f, found := that.Manifest.Lookup(SupervisorFileName)
+	if !found || f.Kind != KindFile {
+		return nil
+	}
+	file := os.NewFile(uintptr(f.Fd), SupervisorFileName)
+	c, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	that.supervisorConn, that.supervisorReader = c, bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(that.StateTimeout))
+	line, err := that.supervisorReader.ReadString('\n')
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	data, err := parseTicketKeysLine(line)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	return that.importTicketKeys(bytes.NewReader(data))
+}
+
+// sendTicketKeys report ticket keys to supervisor in worker process, which passes them to the next worker
+func (that *Grace) sendTicketKeys() {
+	if that.supervisorConn == nil {
+		return
+	}
+	if _, err := that.supervisorConn.Write(that.ticketKeysLine()); err != nil {
+		that.Logger.Debug("cannot send session ticket keys to supervisor", "err", err)
+	}
+}
+
+// notifySupervisor report readiness to supervisor in worker process
+func (that *Grace) notifySupervisor() {
+	c := that.supervisorConn
+	if c == nil {
+		return
+	}
+	if _, err := c.Write([]byte("READY\n")); err != nil {
+		that.Logger.Error("cannot notify supervisor", "err", err)
+		c.Close()
+		return
+	}
+	that.Logger.Info("worker is ready", "supervisor", os.Getppid())
+	go func() {
+		defer c.Close()
+		line, _ := that.supervisorReader.ReadString('\n')
+		if strings.TrimSpace(line) == "HANDOFF" && that.Status.TransitFrom(GraceRunning, GraceReloading, "replaced by new worker") {
+			that.Signal <- syscall.SIGQUIT
+		}
+	}()
+}
@@ -0,0 +1,139 @@
+package gkgrace
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/os/genv"
+	"golang.org/x/sys/unix"
+)
+
+// environment variables set by systemd
+const (
+	SystemdEnvNotifySocket = "NOTIFY_SOCKET"
+	SystemdEnvWatchdogUsec = "WATCHDOG_USEC"
+	SystemdEnvWatchdogPid  = "WATCHDOG_PID"
+)
+
+// SdNotify send state to NOTIFY_SOCKET, return false if the socket is not set
+func SdNotify(state string) (bool, error) {
+	path := genv.Get(SystemdEnvNotifySocket)
+	if path == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:] // abstract namespace
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SdWatchdogInterval return interval of watchdog keepalives expected by systemd, 0 if disabled
+func SdWatchdogInterval(isChild bool) time.Duration {
+	usec, err := strconv.ParseInt(genv.Get(SystemdEnvWatchdogUsec), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// WATCHDOG_PID of a child is its parent, systemd follows MAINPID sent by the child
+	if pid := genv.Get(SystemdEnvWatchdogPid); pid != "" && !isChild && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// initSystemd report status changes to systemd, NotifyAccess=all is required for reloaded processes
+func (that *Grace) initSystemd() {
+	if genv.Get(SystemdEnvNotifySocket) == "" {
+		return
+	}
+	var (
+		mu           sync.Mutex
+		stopping     bool
+		stopWatchdog chan struct{}
+	)
+	stopKeepalive := func() {
+		if stopWatchdog != nil {
+			close(stopWatchdog)
+			stopWatchdog = nil
+		}
+	}
+	that.Subscribe(func(t Transition) {
+		mu.Lock()
+		defer mu.Unlock()
+		state := ""
+		switch t.To {
+		case GraceRunning:
+			state = "READY=1"
+			if t.From == GraceStarting && that.IsChild && !that.Supervised {
+				state = fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid())
+			}
+			if stopWatchdog == nil {
+				stopWatchdog = make(chan struct{})
+				that.startWatchdog(stopWatchdog)
+			}
+		case GraceReloading:
+			var ts unix.Timespec
+			unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+			state = fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", ts.Nano()/1000)
+		case GraceHandedOff:
+			if that.IsSupervisor {
+				break // the supervisor is still the main process
+			}
+			// the child is the main process now
+			stopping = true
+			stopKeepalive()
+		case GraceLameDuck, GraceDraining, GraceExiting:
+			if !stopping {
+				stopping = true
+				state = "STOPPING=1"
+			}
+		case GraceStopped:
+			stopKeepalive()
+		}
+		if state == "" {
+			return
+		}
+		if _, err := SdNotify(state); err != nil {
+			that.Logger.Error("sd_notify failed", "state", strings.ReplaceAll(state, "\n", " "), "err", err)
+		}
+	})
+}
+
+// startWatchdog send WATCHDOG=1 at half of the interval while the process is alive
+func (that *Grace) startWatchdog(stop chan struct{}) {
+	interval := SdWatchdogInterval(that.IsChild)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if code, reason := that.Liveness(); code != http.StatusOK {
+					that.Logger.Warn("watchdog keepalive skipped", "reason", reason)
+					continue
+				}
+				if _, err := SdNotify("WATCHDOG=1"); err != nil {
+					that.Logger.Error("sd_notify failed", "state", "WATCHDOG=1", "err", err)
+				}
+			}
+		}
+	}()
+}
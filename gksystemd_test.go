@@ -0,0 +1,55 @@
+package gkgrace
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv(SystemdEnvNotifySocket, path)
+
+	for _, state := range []string{"READY=1", "RELOADING=1\nMONOTONIC_USEC=1", "STOPPING=1"} {
+		sent, err := SdNotify(state)
+		if !sent || err != nil {
+			t.Fatalf("SdNotify(%q) = %v, %v, want true, nil", state, sent, err)
+		}
+		buf := make([]byte, 256)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != state {
+			t.Fatalf("received %q, want %q", got, state)
+		}
+	}
+}
+
+func TestSdNotifyNoSocket(t *testing.T) {
+	cases := []struct {
+		name   string
+		socket string
+		sent   bool
+		err    bool
+	}{
+		{"not set", "", false, false},
+		{"missing", filepath.Join(t.TempDir(), "missing.sock"), false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv(SystemdEnvNotifySocket, c.socket)
+			sent, err := SdNotify("READY=1")
+			if sent != c.sent || (err != nil) != c.err {
+				t.Fatalf("SdNotify() = %v, %v, want %v, error %v", sent, err, c.sent, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package gkgrace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// name of the state carrying TLS session ticket keys
+const TicketKeysStateName = "gkgrace.ticketkeys"
+
+const (
+	DefaultTicketKeyRotation = time.Hour
+	TicketKeysKept           = 3 // the newest key encrypts tickets, older ones still decrypt
+)
+
+// ticketKeys session ticket keys shared by tls.Configs of Grace, rotated on schedule and handed off during reload,
+// so clients resume sessions with the child process
+type ticketKeys struct {
+	mu       sync.RWMutex
+	keys     [][32]byte
+	rotated  time.Time // time of the newest key
+	gen      uint64    // increased when keys change
+	configs  map[*tls.Config]*ticketConfig
+	started  bool
+	stopped  bool
+	imported chan struct{} // wakes up rotation after keys are imported
+}
+
+// ticketConfig clone of a tls.Config with keys of a generation
+type ticketConfig struct {
+	gen    uint64
+	config *tls.Config
+}
+
+func (that *Grace) initTicketKeys() {
+	that.tickets = &ticketKeys{
+		configs:  make(map[*tls.Config]*ticketConfig),
+		imported: make(chan struct{}, 1),
+	}
+	that.RegisterImporter(TicketKeysStateName, that.importTicketKeys)
+	that.OnTransition(GraceHandedOff, func(Transition) {
+		// keys are exported, new ones would be unknown to the child
+		that.tickets.mu.Lock()
+		that.tickets.stopped = true
+		that.tickets.mu.Unlock()
+	})
+}
+
+// useTicketKeys make config issue tickets with keys of Grace, it is called for configs created by NewTLSConfig
+func (that *Grace) useTicketKeys(config *tls.Config) {
+	if config.SessionTicketsDisabled {
+		return
+	}
+	t := that.tickets
+	t.mu.Lock()
+	if len(t.keys) == 0 {
+		if err := t.rotate(); err != nil {
+			t.mu.Unlock()
+			that.Logger.Error("cannot create session ticket key", "err", err)
+			return
+		}
+	}
+	start := !t.started
+	t.started = true
+	t.mu.Unlock()
+	if start {
+		that.RegisterExporter(TicketKeysStateName, that.exportTicketKeys)
+		that.sendTicketKeys()
+		if that.TicketKeyRotation > 0 {
+			go that.rotateTicketKeys()
+		}
+	}
+	// servers such as http.Server clone the config, keys are applied to every handshake instead
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return t.configFor(config), nil
+	}
+}
+
+// configFor return a clone of config with current keys
+func (that *ticketKeys) configFor(config *tls.Config) *tls.Config {
+	that.mu.RLock()
+	c, found := that.configs[config]
+	if found && c.gen == that.gen {
+		that.mu.RUnlock()
+		return c.config
+	}
+	that.mu.RUnlock()
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	clone := config.Clone()
+	clone.GetConfigForClient = nil
+	clone.SetSessionTicketKeys(that.keys)
+	that.configs[config] = &ticketConfig{gen: that.gen, config: clone}
+	return clone
+}
+
+// rotate add a new key and drop the oldest, mu must be held
+func (that *ticketKeys) rotate() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	that.keys = append([][32]byte{key}, that.keys...)
+	if len(that.keys) > TicketKeysKept {
+		that.keys = that.keys[:TicketKeysKept]
+	}
+	that.rotated = time.Now()
+	that.gen++
+	return nil
+}
+
+func (that *Grace) rotateTicketKeys() {
+	t := that.tickets
+	for {
+		t.mu.RLock()
+		timer := time.NewTimer(time.Until(t.rotated.Add(that.TicketKeyRotation)))
+		t.mu.RUnlock()
+		select {
+		case <-timer.C:
+		case <-t.imported:
+			timer.Stop()
+			continue
+		}
+		t.mu.Lock()
+		if t.stopped {
+			t.mu.Unlock()
+			return
+		}
+		err := t.rotate()
+		t.mu.Unlock()
+		if err != nil {
+			that.Logger.Error("cannot rotate session ticket keys", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		that.Logger.Debug("session ticket keys rotated")
+		that.sendTicketKeys()
+	}
+}
+
+// exportTicketKeys write [time of the newest key][keys...]
+func (that *Grace) exportTicketKeys(w io.Writer) error {
+	t := that.tickets
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	buf := make([]byte, 8, 8+32*len(t.keys))
+	binary.BigEndian.PutUint64(buf, uint64(t.rotated.UnixNano()))
+	for _, key := range t.keys {
+		buf = append(buf, key[:]...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (that *Grace) importTicketKeys(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := that.tickets.set(data); err != nil {
+		return err
+	}
+	that.Logger.Info("session ticket keys inherited", "count", (len(data)-8)/32)
+	return nil
+}
+
+// set replace keys with data written by exportTicketKeys
+func (that *ticketKeys) set(data []byte) error {
+	if len(data) < 8+32 || (len(data)-8)%32 != 0 {
+		return fmt.Errorf("session ticket keys: invalid size %d", len(data))
+	}
+	keys := make([][32]byte, (len(data)-8)/32)
+	for i := range keys {
+		copy(keys[i][:], data[8+32*i:])
+	}
+	that.mu.Lock()
+	that.keys = keys
+	that.rotated = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	that.gen++
+	that.mu.Unlock()
+	select {
+	case that.imported <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ticketKeysLine encode keys as "KEYS <hex>\n" for the socketpair between supervisor and worker, the hex is empty without keys
+func (that *Grace) ticketKeysLine() []byte {
+	t := that.tickets
+	t.mu.RLock()
+	empty := len(t.keys) == 0
+	t.mu.RUnlock()
+	buf := &bytes.Buffer{}
+	if !empty {
+		that.exportTicketKeys(buf)
+	}
+	return []byte("KEYS " + hex.EncodeToString(buf.Bytes()) + "\n")
+}
+
+// parseTicketKeysLine decode a line written by ticketKeysLine, data is empty without keys
+func parseTicketKeysLine(line string) (data []byte, err error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "KEYS") {
+		return nil, fmt.Errorf("session ticket keys: unexpected line %q", line)
+	}
+	return hex.DecodeString(strings.TrimSpace(strings.TrimPrefix(line, "KEYS")))
+}
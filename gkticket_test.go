@@ -0,0 +1,142 @@
+package gkgrace
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gkgrace.test"},
+		DNSNames:     []string{"gkgrace.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake connect client to a server with config, it returns whether the session is resumed
+func handshake(t *testing.T, config, client *tls.Config) bool {
+	s, c := net.Pipe()
+	defer s.Close()
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() { done <- tls.Server(s, config).Handshake() }()
+	conn := tls.Client(c, client)
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+	return conn.ConnectionState().DidResume
+}
+
+// TestTicketKeysSupervisor pass keys from a worker to the next one through supervisor, as supervisor mode does on reload
+func TestTicketKeysSupervisor(t *testing.T) {
+	cert := testCertificate(t)
+	cases := []struct {
+		name   string
+		pass   bool // keys of the old worker are passed to the new one
+		rotate bool // the new worker rotates keys after import
+		resume bool
+	}{
+		{"passed", true, false, true},
+		{"passed and rotated", true, true, true},
+		{"not passed", false, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &tls.Config{
+				ServerName:         "gkgrace.test",
+				InsecureSkipVerify: true,
+				MaxVersion:         tls.VersionTLS12, // the ticket is issued during handshake
+				ClientSessionCache: tls.NewLRUClientSessionCache(1),
+			}
+			old, supervisor, next := New(), New(), New()
+
+			// the old worker issues a ticket and reports its keys
+			sc, wc := net.Pipe()
+			defer sc.Close()
+			old.supervisorConn = wc
+			configs := make(chan *tls.Config, 1)
+			go func() {
+				config, err := old.NewTLSConfig(&TLSOptions{Certificates: []tls.Certificate{cert}})
+				if err != nil {
+					t.Error(err)
+				}
+				configs <- config
+			}()
+			line, err := bufio.NewReader(sc).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := <-configs
+			if config == nil {
+				t.FailNow()
+			}
+			if handshake(t, config, client) {
+				t.Fatal("first handshake is resumed")
+			}
+			if c.pass {
+				data, err := parseTicketKeysLine(line)
+				if err == nil {
+					err = supervisor.tickets.set(data)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// supervisor writes its keys to the socketpair before the next worker starts
+			fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			local := os.NewFile(uintptr(fds[0]), SupervisorFileName)
+			defer local.Close()
+			if _, err := local.Write(supervisor.ticketKeysLine()); err != nil {
+				t.Fatal(err)
+			}
+			next.Manifest = NewManifest(1)
+			// the fd is not moved by ExtraFiles in the same process
+			next.Manifest.Files = []HandoffFile{{Name: SupervisorFileName, Kind: KindFile, Fd: fds[1]}}
+			if err := next.connectSupervisor(); err != nil {
+				t.Fatalf("connectSupervisor() = %v", err)
+			}
+			defer next.supervisorConn.Close()
+			if c.rotate {
+				next.tickets.mu.Lock()
+				next.tickets.rotate()
+				next.tickets.mu.Unlock()
+			}
+			config, err = next.NewTLSConfig(&TLSOptions{Certificates: []tls.Certificate{cert}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resumed := handshake(t, config, client); resumed != c.resume {
+				t.Fatalf("resumed = %v, want %v", resumed, c.resume)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package gkgrace
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions TLS configuration accepted by every app
+type TLSOptions struct {
+	CertFiles              []string           // pairs of certFile and keyFile, reloaded without restart
+	Certificates           []tls.Certificate  // certificates in memory
+	ClientCAFiles          []string           // PEM files of CAs verifying client certificates
+	ClientCAs              *x509.CertPool     // CAs verifying client certificates
+	ClientAuth             tls.ClientAuthType // mTLS mode, RequireAndVerifyClientCert if CAs are set and it is not
+	MinVersion             uint16             // tls.VersionTLS12 if not set
+	CipherSuites           []uint16           // defaults of crypto/tls if empty, not configurable in TLS 1.3
+	NextProtos             []string           // ALPN, defaults of the app if empty
+	SessionTicketsDisabled bool               // otherwise ticket keys are shared by apps and survive reloads
+}
+
+// TLSFiles return TLSOptions of certs: certFile, keyFile, [certFile, keyFile]...
+func TLSFiles(certs ...string) *TLSOptions {
+	return &TLSOptions{CertFiles: certs}
+}
+
+// Validate check options without loading files
+func (that *TLSOptions) Validate() error {
+	if len(that.CertFiles)%2 != 0 {
+		return fmt.Errorf("tls: certs must be pairs of certFile and keyFile, got %d file(s): %v", len(that.CertFiles), that.CertFiles)
+	}
+	if len(that.CertFiles) == 0 && len(that.Certificates) == 0 {
+		return fmt.Errorf("tls: no certificate, set CertFiles or Certificates")
+	}
+	switch that.MinVersion {
+	case 0, tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+	default:
+		return fmt.Errorf("tls: unknown MinVersion 0x%04x", that.MinVersion)
+	}
+	for _, id := range that.CipherSuites {
+		if !isSecureCipherSuite(id) {
+			return fmt.Errorf("tls: cipher suite %s is unknown or insecure", tls.CipherSuiteName(id))
+		}
+	}
+	if that.ClientAuth > tls.RequireAndVerifyClientCert {
+		return fmt.Errorf("tls: unknown ClientAuth %d", that.ClientAuth)
+	}
+	if that.ClientAuth >= tls.VerifyClientCertIfGiven && that.ClientCAs == nil && len(that.ClientCAFiles) == 0 {
+		return fmt.Errorf("tls: ClientAuth %s needs ClientCAs or ClientCAFiles", that.ClientAuth)
+	}
+	return nil
+}
+
+func isSecureCipherSuite(id uint16) bool {
+	for _, s := range tls.CipherSuites() {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// clientCAs return pool of ClientCAs and ClientCAFiles, nil if neither is set
+func (that *TLSOptions) clientCAs() (*x509.CertPool, error) {
+	if len(that.ClientCAFiles) == 0 {
+		return that.ClientCAs, nil
+	}
+	pool := that.ClientCAs
+	if pool == nil {
+		pool = x509.NewCertPool()
+	} else {
+		pool = pool.Clone()
+	}
+	for _, f := range that.ClientCAFiles {
+		pem, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("tls: cannot read client CA %s: %s", f, err.Error())
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificate found in client CA %s", f)
+		}
+	}
+	return pool, nil
+}
+
+// NewTLSConfig return a tls.Config of options, certificates are served by a CertManager of their files,
+// it is reloaded by "RELOAD-CERTS" control command and when files change
+func (that *Grace) NewTLSConfig(opts *TLSOptions) (*tls.Config, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("tls: options are nil")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	cas, err := opts.clientCAs()
+	if err != nil {
+		return nil, err
+	}
+	m, err := that.certManagerOf(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config := m.TLSConfig()
+	if opts.MinVersion != 0 {
+		config.MinVersion = opts.MinVersion
+	}
+	config.CipherSuites = opts.CipherSuites
+	config.NextProtos = opts.NextProtos
+	config.SessionTicketsDisabled = opts.SessionTicketsDisabled
+	config.ClientCAs = cas
+	config.ClientAuth = opts.ClientAuth
+	if cas != nil && config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	that.useTicketKeys(config)
+	return config, nil
+}
@@ -0,0 +1,190 @@
+package gkgrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// name of the inherited pipe carrying application state
+const StateFileName = "gkgrace.state"
+
+// default limits of state handoff
+const (
+	DefaultMaxStateSize int64 = 64 << 20
+	DefaultStateTimeout       = 10 * time.Second
+)
+
+// StateExporter writes state into w in parent process during reload
+type StateExporter func(w io.Writer) error
+
+// StateImporter reads state exported by parent process
+type StateImporter func(r io.Reader) error
+
+// RegisterExporter register an exporter called in parent process during reload
+func (that *Grace) RegisterExporter(name string, fn StateExporter) {
+	that.exporters.Set(name, fn)
+}
+
+// RegisterImporter register an importer called in child process for state exported with the same name
+func (that *Grace) RegisterImporter(name string, fn StateImporter) {
+	that.importers.Set(name, fn)
+}
+
+// prepareStateExport pass a pipe to child process if any exporter is registered,
+// supervisor has no state but ticket keys, which are passed over the socketpair, see prepareSupervision
+func (that *Grace) prepareStateExport(h *handoff) error {
+	if that.exporters.Size() == 0 || that.IsSupervisor {
+		return nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	h.addFile(StateFileName, r)
+	h.started = append(h.started, func() {
+		done := make(chan struct{})
+		that.stateExported = done
+		go func() {
+			defer close(done)
+			defer w.Close()
+			if err := that.exportState(w); err != nil {
+				that.Logger.Error("state export failed", "phase", GraceReloading, "err", err)
+			}
+		}()
+	})
+	h.aborted = append(h.aborted, func() {
+		w.Close()
+	})
+	return nil
+}
+
+// exportState write every exporter into w as frames: [name length][name][data length][data]
+func (that *Grace) exportState(w *os.File) error {
+	w.SetWriteDeadline(time.Now().Add(that.StateTimeout))
+	names := that.exporters.Keys()
+	sort.Strings(names)
+	budget := that.MaxStateSize
+	for _, name := range names {
+		fn := that.exporters.Get(name).(StateExporter)
+		buf := &limitedBuffer{max: budget}
+		if err := that.runHook("export:"+name, func() error { return fn(buf) }); err != nil {
+			that.Logger.Error("state exporter failed, skipped", "state", name, "err", err)
+			continue
+		}
+		if err := writeFrame(w, name, buf.data); err != nil {
+			return err
+		}
+		budget -= int64(len(buf.data))
+	}
+	return writeFrame(w, "", nil) // end of stream
+}
+
+// ImportState call importers with state exported by parent, it is called by Wait if not called before
+func (that *Grace) ImportState() (err error) {
+	that.importOnce.Do(func() {
+		if that.Supervised {
+			if err := that.connectSupervisor(); err != nil {
+				that.Logger.Error("cannot connect to supervisor", "err", err)
+			}
+		}
+		err = that.importState()
+	})
+	return
+}
+
+func (that *Grace) importState() error {
+	f, found := that.Manifest.Lookup(StateFileName)
+	if !found || f.Kind != KindFile {
+		return nil
+	}
+	syscall.SetNonblock(f.Fd, true) // make the pipe pollable for deadlines
+	file := os.NewFile(uintptr(f.Fd), StateFileName)
+	defer file.Close()
+	file.SetReadDeadline(time.Now().Add(that.StateTimeout))
+	var total int64
+	for {
+		name, size, err := readFrameHeader(file)
+		if err != nil {
+			return fmt.Errorf("state: %s", err.Error())
+		}
+		if name == "" {
+			return nil
+		}
+		total += size
+		if total > that.MaxStateSize {
+			return fmt.Errorf("state: size exceeds %d bytes", that.MaxStateSize)
+		}
+		r := io.LimitReader(file, size)
+		if fn, ok := that.importers.Get(name).(StateImporter); ok {
+			if err := that.runHook("import:"+name, func() error { return fn(r) }); err != nil {
+				that.Logger.Error("state importer failed", "state", name, "err", err)
+			}
+		} else {
+			that.Logger.Warn("no importer for state, skipped", "state", name)
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return fmt.Errorf("state: %s", err.Error())
+		}
+	}
+}
+
+// waitStateExport wait until state is written to child process or timeout
+func (that *Grace) waitStateExport() {
+	if that.stateExported == nil {
+		return
+	}
+	select {
+	case <-that.stateExported:
+	case <-time.After(that.StateTimeout):
+		that.Logger.Error("state export timeout!", "phase", GraceHandedOff)
+	}
+}
+
+type limitedBuffer struct {
+	data []byte
+	max  int64
+}
+
+func (that *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(len(that.data)+len(p)) > that.max {
+		return 0, fmt.Errorf("state: size exceeds %d bytes", that.max)
+	}
+	that.data = append(that.data, p...)
+	return len(p), nil
+}
+
+func writeFrame(w io.Writer, name string, data []byte) error {
+	header := make([]byte, 4+len(name)+8)
+	binary.BigEndian.PutUint32(header, uint32(len(name)))
+	copy(header[4:], name)
+	binary.BigEndian.PutUint64(header[4+len(name):], uint64(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readFrameHeader(r io.Reader) (name string, size int64, err error) {
+	var n uint32
+	if err = binary.Read(r, binary.BigEndian, &n); err != nil {
+		return
+	}
+	if n > 4096 {
+		return "", 0, fmt.Errorf("invalid name length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return
+	}
+	var s uint64
+	if err = binary.Read(r, binary.BigEndian, &s); err != nil {
+		return
+	}
+	return string(b), int64(s), nil
+}
@@ -0,0 +1,123 @@
+package gkgrace
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RestartFile is touched to reload the process, relative to WorkingDir
+const RestartFile = "tmp/restart.txt"
+
+const (
+	DefaultWatchInterval = time.Second
+	DefaultWatchDebounce = 2 * time.Second
+)
+
+// Watch reload the process when the executable, RestartFile or files change, call it before Wait.
+// Files are polled every WatchInterval, a change is applied when the file is unchanged for WatchDebounce,
+// so binaries being written are skipped. ConfigFile is watched too and reloaded in place, see ReloadConfig.
+func (that *Grace) Watch(files ...string) {
+	that.watching = true
+	that.watchFiles = append(that.watchFiles, files...)
+}
+
+// watchedFile is the last seen state of a watched file
+type watchedFile struct {
+	path    string
+	config  bool // reloaded by ReloadConfig
+	exe     bool // must be executable
+	modTime time.Time
+	size    int64
+	changed time.Time // zero if no change is pending
+}
+
+// stat return true if the file changed since the last call
+func (that *watchedFile) stat() bool {
+	var (
+		modTime time.Time
+		size    int64 = -1 // not found
+	)
+	if info, err := os.Stat(that.path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	if modTime.Equal(that.modTime) && size == that.size {
+		return false
+	}
+	that.modTime, that.size = modTime, size
+	return true
+}
+
+// ready return true if the file exists and can be used, a removed RestartFile does not reload
+func (that *watchedFile) ready() bool {
+	info, err := os.Stat(that.path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return !that.exe || (info.Size() > 0 && info.Mode()&0111 != 0)
+}
+
+// watch poll watched files until the process stops serving
+func (that *Grace) watch() {
+	if !that.watching || that.isWorker() {
+		return
+	}
+	var files []*watchedFile
+	if exe, err := os.Executable(); err == nil {
+		files = append(files, &watchedFile{path: exe, exe: true})
+	} else {
+		that.Logger.Warn("executable is not watched", "err", err)
+	}
+	files = append(files, &watchedFile{path: filepath.Join(WorkingDir, RestartFile)})
+	if that.ConfigFile != "" {
+		files = append(files, &watchedFile{path: that.ConfigFile, config: true})
+	}
+	for _, f := range that.watchFiles {
+		files = append(files, &watchedFile{path: f})
+	}
+	for _, f := range files {
+		f.stat()
+	}
+	that.Logger.Info("watching files for reload", "files", len(files), "interval", that.WatchInterval)
+
+	ticker := time.NewTicker(that.WatchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if that.Status.Is(GraceDraining, GraceHandedOff, GraceExiting, GraceStopped) {
+			return
+		}
+		var reload, reloadConfig string
+		now := time.Now()
+		for _, f := range files {
+			if f.stat() {
+				f.changed = now // debounce again while it is being written
+				continue
+			}
+			if f.changed.IsZero() || now.Sub(f.changed) < that.WatchDebounce || !that.Status.Is(GraceRunning) {
+				continue
+			}
+			f.changed = time.Time{}
+			if !f.ready() {
+				that.Logger.Warn("changed file is not ready, reload skipped", "file", f.path)
+				continue
+			}
+			if f.config {
+				reloadConfig = f.path
+			} else {
+				reload = f.path
+			}
+		}
+		switch {
+		case reload != "":
+			that.Logger.Info("watched file changed, reloading", "file", reload)
+			if err := that.reload(); err != nil {
+				that.Logger.Error("cannot reload", "err", err)
+			}
+		case reloadConfig != "":
+			that.Logger.Info("config file changed, reloading config", "file", reloadConfig)
+			if err := that.ReloadConfig(); err != nil {
+				that.Logger.Error("cannot reload config", "err", err)
+			}
+		}
+	}
+}